@@ -0,0 +1,210 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// builtinSQL holds queries of the built-in keys. A query may have variants for different server versions:
+// "name.sql" is used for all versions and "name.vNN.sql" for PostgreSQL NN and newer. The variant with
+// the highest version not exceeding the server version is used.
+//
+//go:embed sql/*.sql
+var builtinSQL embed.FS
+
+var builtinCatalogue = mustLoadCatalogue(builtinSQL, "sql")
+
+var reVersionedQuery = regexp.MustCompile(`^(.+)\.v(\d+)$`)
+
+type queryVariant struct {
+	minVersion int
+	file       string
+	sql        string
+	// override is set for variants taken from the custom queries directory.
+	override bool
+}
+
+// sqlCatalogue holds queries by name with their version variants sorted by minVersion in descending order.
+type sqlCatalogue struct {
+	queries map[string][]queryVariant
+}
+
+func mustLoadCatalogue(fsys fs.FS, dir string) *sqlCatalogue {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+
+	files := make(map[string]string, len(entries))
+
+	for _, e := range entries {
+		b, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		files[e.Name()] = string(b)
+	}
+
+	return newCatalogue(files)
+}
+
+// newCatalogue creates a catalogue from a map of file names to their contents. Files without the .sql
+// extension are ignored.
+func newCatalogue(files map[string]string) *sqlCatalogue {
+	c := &sqlCatalogue{queries: make(map[string][]queryVariant)}
+
+	for file, sql := range files {
+		c.add(file, sql, false)
+	}
+
+	return c
+}
+
+// parseQueryFile returns a query name and the minimal server version of a query file.
+func parseQueryFile(file string) (name string, minVersion int, ok bool) {
+	if !strings.HasSuffix(file, sqlExt) {
+		return "", 0, false
+	}
+
+	name = strings.TrimSuffix(file, sqlExt)
+
+	if m := reVersionedQuery.FindStringSubmatch(name); m != nil {
+		major, err := strconv.Atoi(m[2])
+		if err == nil {
+			return m[1], major * 10000, true
+		}
+	}
+
+	return name, 0, true
+}
+
+// add adds a query variant, replacing a variant of the same query and version.
+func (c *sqlCatalogue) add(file, sql string, override bool) {
+	name, minVersion, ok := parseQueryFile(file)
+	if !ok {
+		return
+	}
+
+	variants := c.queries[name]
+
+	for i, v := range variants {
+		if v.minVersion == minVersion {
+			variants = append(variants[:i:i], variants[i+1:]...)
+
+			break
+		}
+	}
+
+	variants = append(variants, queryVariant{minVersion: minVersion, file: file, sql: sql, override: override})
+	sort.Slice(variants, func(i, j int) bool { return variants[i].minVersion > variants[j].minVersion })
+
+	c.queries[name] = variants
+}
+
+// withOverrides returns a copy of the catalogue where files of known queries are replaced or complemented
+// by the given ones, along with the names of the files used and of the built-in files replaced by them.
+// An override applies from its version up: built-in variants of the same or a newer version are dropped,
+// so pgsql.dbstat.sql replaces pgsql.dbstat.v12.sql too. Files of unknown queries are ignored,
+// so custom queries are not mixed with the built-in ones.
+func (c *sqlCatalogue) withOverrides(files map[string]string) (res *sqlCatalogue, used, replaced []string) {
+	// minVersions holds the lowest version of overrides of each query.
+	minVersions := make(map[string]int)
+
+	for file := range files {
+		name, minVersion, ok := parseQueryFile(file)
+		if !ok {
+			continue
+		}
+
+		if _, ok = c.queries[name]; !ok {
+			continue
+		}
+
+		if v, ok := minVersions[name]; !ok || minVersion < v {
+			minVersions[name] = minVersion
+		}
+
+		used = append(used, file)
+	}
+
+	res = &sqlCatalogue{queries: make(map[string][]queryVariant, len(c.queries))}
+
+	for name, variants := range c.queries {
+		minVersion, overridden := minVersions[name]
+
+		for _, v := range variants {
+			if overridden && v.minVersion >= minVersion {
+				replaced = append(replaced, v.file)
+
+				continue
+			}
+
+			res.queries[name] = append(res.queries[name], v)
+		}
+	}
+
+	for _, file := range used {
+		res.add(file, files[file], true)
+	}
+
+	sort.Strings(used)
+	sort.Strings(replaced)
+
+	return res, used, replaced
+}
+
+// get returns the variant of a query for a given server version.
+func (c *sqlCatalogue) get(name string, version int) (string, error) {
+	v, err := c.variant(name, version)
+	if err != nil {
+		return "", err
+	}
+
+	return v.text(), nil
+}
+
+// variant returns the variant of a query for a given server version.
+func (c *sqlCatalogue) variant(name string, version int) (queryVariant, error) {
+	variants, ok := c.queries[name]
+	if !ok {
+		return queryVariant{}, fmt.Errorf("built-in query %q not found", name)
+	}
+
+	for _, v := range variants {
+		if version >= v.minVersion {
+			return v, nil
+		}
+	}
+
+	return queryVariant{}, fmt.Errorf("built-in query %q is not available for PostgreSQL %s", name,
+		formatVersion(version))
+}
+
+// text returns the query without surrounding whitespace and the trailing semicolon.
+func (v queryVariant) text() string {
+	return strings.TrimRight(strings.TrimSpace(v.sql), ";")
+}
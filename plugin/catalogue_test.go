@@ -0,0 +1,190 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseQueryFile(t *testing.T) {
+	tests := []struct {
+		file           string
+		wantName       string
+		wantMinVersion int
+		wantOk         bool
+	}{
+		{"pgsql.dbstat.sql", "pgsql.dbstat", 0, true},
+		{"pgsql.dbstat.v12.sql", "pgsql.dbstat", 120000, true},
+		{"pgsql.dbstat.sum.v12.sql", "pgsql.dbstat.sum", 120000, true},
+		{"pgsql.dbstat.vx.sql", "pgsql.dbstat.vx", 0, true},
+		{"README.md", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			name, minVersion, ok := parseQueryFile(tt.file)
+			if name != tt.wantName || minVersion != tt.wantMinVersion || ok != tt.wantOk {
+				t.Errorf("parseQueryFile() = %v, %v, %v, want %v, %v, %v",
+					name, minVersion, ok, tt.wantName, tt.wantMinVersion, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_sqlCatalogue_get(t *testing.T) {
+	c := newCatalogue(map[string]string{
+		"q.sql":       "SELECT 10;\n",
+		"q.v12.sql":   "SELECT 12;",
+		"q.v14.sql":   "SELECT 14;",
+		"new.v13.sql": "SELECT 13;",
+	})
+
+	tests := []struct {
+		name    string
+		query   string
+		version int
+		want    string
+		wantErr bool
+	}{
+		{"base", "q", 110005, "SELECT 10", false},
+		{"exact version", "q", 120000, "SELECT 12", false},
+		{"between versions", "q", 130004, "SELECT 12", false},
+		{"newest", "q", 160000, "SELECT 14", false},
+		{"no variant for version", "new", 120000, "", true},
+		{"unknown", "unknown", 160000, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.get(tt.query, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("sqlCatalogue.get() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("sqlCatalogue.get() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_sqlCatalogue_withOverrides(t *testing.T) {
+	base := newCatalogue(map[string]string{
+		"q.sql":     "SELECT 10",
+		"q.v12.sql": "SELECT 12",
+	})
+
+	c, used, replaced := base.withOverrides(map[string]string{
+		"q.v12.sql":   "SELECT 'fixed 12'",
+		"q.v17.sql":   "SELECT 17",
+		"custom.sql":  "SELECT 'custom'",
+		"q.v12.sql.b": "SELECT 'backup'",
+	})
+
+	if want := []string{"q.v12.sql", "q.v17.sql"}; !reflect.DeepEqual(used, want) {
+		t.Errorf("sqlCatalogue.withOverrides() used = %v, want %v", used, want)
+	}
+
+	if want := []string{"q.v12.sql"}; !reflect.DeepEqual(replaced, want) {
+		t.Errorf("sqlCatalogue.withOverrides() replaced = %v, want %v", replaced, want)
+	}
+
+	for version, want := range map[int]string{100000: "SELECT 10", 130000: "SELECT 'fixed 12'", 170000: "SELECT 17"} {
+		if got, _ := c.get("q", version); got != want {
+			t.Errorf("overridden sqlCatalogue.get(%d) = %q, want %q", version, got, want)
+		}
+	}
+
+	for version, want := range map[int]bool{100000: false, 130000: true, 170000: true} {
+		if v, _ := c.variant("q", version); v.override != want {
+			t.Errorf("overridden sqlCatalogue.variant(%d).override = %v, want %v", version, v.override, want)
+		}
+	}
+
+	if _, err := c.get("custom", 170000); err == nil {
+		t.Errorf("sqlCatalogue.withOverrides() added a custom query")
+	}
+
+	if got, _ := base.get("q", 130000); got != "SELECT 12" {
+		t.Errorf("sqlCatalogue.withOverrides() modified the original catalogue: %q", got)
+	}
+}
+
+func Test_sqlCatalogue_withOverrides_versioned(t *testing.T) {
+	base := newCatalogue(map[string]string{
+		"q.sql":     "SELECT 10",
+		"q.v12.sql": "SELECT 12",
+		"q.v16.sql": "SELECT 16",
+	})
+
+	tests := []struct {
+		name     string
+		files    map[string]string
+		want     map[int]string
+		replaced []string
+	}{
+		{
+			"unversioned override replaces all variants",
+			map[string]string{"q.sql": "SELECT 'fixed'"},
+			map[int]string{100000: "SELECT 'fixed'", 130000: "SELECT 'fixed'", 170000: "SELECT 'fixed'"},
+			[]string{"q.sql", "q.v12.sql", "q.v16.sql"},
+		},
+		{
+			"versioned override replaces newer variants",
+			map[string]string{"q.v14.sql": "SELECT 'fixed 14'"},
+			map[int]string{100000: "SELECT 10", 130000: "SELECT 12", 140000: "SELECT 'fixed 14'", 170000: "SELECT 'fixed 14'"},
+			[]string{"q.v16.sql"},
+		},
+		{
+			"several overrides",
+			map[string]string{"q.sql": "SELECT 'fixed'", "q.v16.sql": "SELECT 'fixed 16'"},
+			map[int]string{100000: "SELECT 'fixed'", 130000: "SELECT 'fixed'", 170000: "SELECT 'fixed 16'"},
+			[]string{"q.sql", "q.v12.sql", "q.v16.sql"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _, replaced := base.withOverrides(tt.files)
+
+			for version, want := range tt.want {
+				if got, _ := c.get("q", version); got != want {
+					t.Errorf("overridden sqlCatalogue.get(%d) = %q, want %q", version, got, want)
+				}
+			}
+
+			if !reflect.DeepEqual(replaced, tt.replaced) {
+				t.Errorf("sqlCatalogue.withOverrides() replaced = %v, want %v", replaced, tt.replaced)
+			}
+		})
+	}
+}
+
+func Test_builtinCatalogue(t *testing.T) {
+	for name, variants := range builtinCatalogue.queries {
+		for _, v := range variants {
+			if v.sql == "" {
+				t.Errorf("built-in query %s (%s) is empty", name, v.file)
+			}
+		}
+	}
+
+	for _, name := range []string{keyDBStat, keyDBStatSum, keyPing, keyQueries, keyBuffercache} {
+		if _, err := builtinCatalogue.get(name, MinSupportedPGVersion); err != nil {
+			t.Errorf("built-in query %s: %v", name, err)
+		}
+	}
+}
@@ -18,19 +18,52 @@
 package plugin
 
 import (
+	"fmt"
+	"time"
+
 	"git.zabbix.com/ap/plugin-support/conf"
 	"git.zabbix.com/ap/plugin-support/plugin"
 )
 
+// defaultApplicationName is the application_name of monitoring connections if it is not configured.
+const defaultApplicationName = "zabbix_agent2_postgresql"
+
+// defaultLockTimeout is the lock_timeout of monitoring connections if it is not configured. It is short,
+// so that a monitoring query blocked by an exclusive lock fails fast instead of queuing other sessions behind it.
+const defaultLockTimeout = 3 * time.Second
+
+// Supported values of the TargetSessionAttrs option, they have the same meaning as in libpq.
+const (
+	targetSessionAttrsAny           = "any"
+	targetSessionAttrsReadWrite     = "read-write"
+	targetSessionAttrsReadOnly      = "read-only"
+	targetSessionAttrsPrimary       = "primary"
+	targetSessionAttrsStandby       = "standby"
+	targetSessionAttrsPreferStandby = "prefer-standby"
+)
+
 // Session struct holds individual options for PostgreSQL connection for each session.
 type Session struct {
 	// URI is a connection string consisting of a network scheme, a host address and a port or a path to a Unix-socket.
+	// Several comma-separated addresses may be set for a cluster, they are tried in order.
 	URI string `conf:"name=Uri,optional"`
 
+	// TargetSessionAttrs determines which host of a multi-host URI is acceptable for monitoring.
+	TargetSessionAttrs string `conf:"optional"`
+
+	// Service is a name of a pg_service.conf entry to take connection settings from,
+	// the settings explicitly set in the session take precedence.
+	Service string `conf:"optional"`
+
+	// PassFile is a full pathname of a password file with .pgpass semantics
+	// to look up a password in if the session has none.
+	PassFile string `conf:"optional"`
+
 	// User of PostgreSQL server.
 	User string `conf:"optional"`
 
-	// Password to send to protected PostgreSQL server.
+	// Password to send to protected PostgreSQL server. It can also be a reference to a secret:
+	// "file:<path>", "env:<variable>" or "exec:<helper command>".
 	Password string `conf:"optional"`
 
 	// Database of PostgreSQL server.
@@ -47,6 +80,46 @@ type Session struct {
 
 	// Key filepath for PostgreSQL server.
 	TLSKeyFile string `conf:"name=TLSKeyFile,optional"`
+
+	// Password of an encrypted key. It can also be a reference to a secret the same as Password.
+	TLSKeyPassword string `conf:"name=TLSKeyPassword,optional"`
+
+	// Certificate revocation list filepath.
+	TLSCRLFile string `conf:"name=TLSCRLFile,optional"`
+
+	// Minimum TLS protocol version: TLSv1, TLSv1.1, TLSv1.2 or TLSv1.3.
+	TLSMinVersion string `conf:"name=TLSMinVersion,optional"`
+
+	// Server name to verify the certificate against and to send with SNI instead of the host name.
+	TLSServerName string `conf:"name=TLSServerName,optional"`
+
+	// TLSUseSystemCA enables the system root CA pool in addition to TLSCAFile.
+	TLSUseSystemCA int `conf:"name=TLSUseSystemCA,optional,range=0:1"`
+
+	// MaxOpenConns overrides the plugin-level maximum number of open connections to a database.
+	MaxOpenConns int `conf:"optional,range=0:1000"`
+
+	// MaxIdleConns overrides the plugin-level maximum number of idle connections to a database.
+	MaxIdleConns int `conf:"optional,range=0:1000"`
+
+	// ConnMaxLifetime overrides the plugin-level maximum time in seconds a connection may be reused.
+	ConnMaxLifetime int `conf:"optional,range=0:86400"`
+
+	// ConnMaxIdleTime overrides the plugin-level maximum time in seconds a connection may be idle.
+	ConnMaxIdleTime int `conf:"optional,range=0:86400"`
+
+	// ApplicationName overrides the plugin-level application_name of monitoring connections.
+	ApplicationName string `conf:"optional"`
+
+	// StatementTimeout is the server-side statement_timeout in seconds. Defaults to CallTimeout.
+	StatementTimeout int `conf:"optional,range=0:3600"`
+
+	// LockTimeout is the server-side lock_timeout in seconds. Defaults to 3 seconds or CallTimeout if it is shorter.
+	LockTimeout int `conf:"optional,range=0:3600"`
+
+	// IdleInTransactionSessionTimeout is the server-side idle_in_transaction_session_timeout in seconds.
+	// Defaults to CallTimeout.
+	IdleInTransactionSessionTimeout int `conf:"optional,range=0:3600"`
 }
 
 // PluginOptions are options for PostgreSQL connection.
@@ -69,6 +142,47 @@ type PluginOptions struct {
 
 	// CustomQueriesPath is a full pathname of a directory containing *.sql files with custom queries.
 	CustomQueriesPath string `conf:"optional"`
+
+	// CustomQueriesReloadInterval is a time in seconds between checks of the custom queries directory
+	// for changed files, 0 disables reloading.
+	CustomQueriesReloadInterval int `conf:"optional,range=0:3600,default=10"`
+
+	// CustomQueriesReadOnly runs custom queries in read-only transactions unless a query declares otherwise.
+	CustomQueriesReadOnly int `conf:"optional,range=0:1,default=1"`
+
+	// CustomQueriesAllowList is a comma-separated list of schemas and functions custom queries may reference,
+	// empty means no restriction.
+	CustomQueriesAllowList string `conf:"optional"`
+
+	// MaxOpenConns is the maximum number of open connections to a database, 0 means unlimited.
+	MaxOpenConns int `conf:"optional,range=0:1000,default=0"`
+
+	// MaxIdleConns is the maximum number of idle connections to a database kept open, 0 means none.
+	MaxIdleConns int `conf:"optional,range=0:1000,default=2"`
+
+	// ConnMaxLifetime is the maximum time in seconds a connection may be reused, 0 means unlimited.
+	ConnMaxLifetime int `conf:"optional,range=0:86400,default=0"`
+
+	// ConnMaxIdleTime is the maximum time in seconds a connection may be idle, 0 means unlimited.
+	ConnMaxIdleTime int `conf:"optional,range=0:86400,default=0"`
+
+	// ApplicationName is the application_name reported by monitoring connections to PostgreSQL.
+	ApplicationName string `conf:"optional"`
+
+	// UseEnvironment enables PG* environment variables, a default .pgpass and pg_service.conf,
+	// to complete connection settings which are not set explicitly, the same way as libpq does.
+	UseEnvironment int `conf:"optional,range=0:1,default=0"`
+
+	// SecretTTL is a time in seconds to cache the output of a helper program referenced by a session password.
+	SecretTTL int `conf:"optional,range=0:86400,default=300"`
+
+	// AllowKeyTLSParams enables TLS parameters set by libpq names in the query of a URI,
+	// so TLS can be configured by key parameters.
+	AllowKeyTLSParams int `conf:"optional,range=0:1,default=0"`
+
+	// NumericPrecision is the number of digits after the decimal point fractional numbers of JSON results
+	// are rounded to, -1 means no rounding.
+	NumericPrecision int `conf:"optional,range=-1:20,default=-1"`
 }
 
 // Configure implements the Configurator interface.
@@ -85,6 +199,71 @@ func (p *Plugin) Configure(global *plugin.GlobalOptions, options interface{}) {
 	if p.options.CallTimeout == 0 {
 		p.options.CallTimeout = global.Timeout
 	}
+
+	if p.options.ApplicationName == "" {
+		p.options.ApplicationName = defaultApplicationName
+	}
+}
+
+// connOptions returns connection options of a given session, falling back to the plugin-level ones
+// for options which are not set in the session or if there is no such session.
+func (p *Plugin) connOptions(sessionName string) connOptions {
+	callTimeout := time.Duration(p.options.CallTimeout) * time.Second
+
+	lockTimeout := defaultLockTimeout
+	if callTimeout < lockTimeout {
+		lockTimeout = callTimeout
+	}
+
+	opts := connOptions{
+		maxOpenConns:                    p.options.MaxOpenConns,
+		maxIdleConns:                    p.options.MaxIdleConns,
+		connMaxLifetime:                 time.Duration(p.options.ConnMaxLifetime) * time.Second,
+		connMaxIdleTime:                 time.Duration(p.options.ConnMaxIdleTime) * time.Second,
+		applicationName:                 p.options.ApplicationName,
+		statementTimeout:                callTimeout,
+		lockTimeout:                     lockTimeout,
+		idleInTransactionSessionTimeout: callTimeout,
+	}
+
+	session, ok := p.options.Sessions[sessionName]
+	if !ok {
+		return opts
+	}
+
+	if session.MaxOpenConns != 0 {
+		opts.maxOpenConns = session.MaxOpenConns
+	}
+
+	if session.MaxIdleConns != 0 {
+		opts.maxIdleConns = session.MaxIdleConns
+	}
+
+	if session.ConnMaxLifetime != 0 {
+		opts.connMaxLifetime = time.Duration(session.ConnMaxLifetime) * time.Second
+	}
+
+	if session.ConnMaxIdleTime != 0 {
+		opts.connMaxIdleTime = time.Duration(session.ConnMaxIdleTime) * time.Second
+	}
+
+	if session.ApplicationName != "" {
+		opts.applicationName = session.ApplicationName
+	}
+
+	if session.StatementTimeout != 0 {
+		opts.statementTimeout = time.Duration(session.StatementTimeout) * time.Second
+	}
+
+	if session.LockTimeout != 0 {
+		opts.lockTimeout = time.Duration(session.LockTimeout) * time.Second
+	}
+
+	if session.IdleInTransactionSessionTimeout != 0 {
+		opts.idleInTransactionSessionTimeout = time.Duration(session.IdleInTransactionSessionTimeout) * time.Second
+	}
+
+	return opts
 }
 
 // Validate implements the Configurator interface.
@@ -92,5 +271,26 @@ func (p *Plugin) Configure(global *plugin.GlobalOptions, options interface{}) {
 func (p *Plugin) Validate(options interface{}) error {
 	var opts PluginOptions
 
-	return conf.Unmarshal(options, &opts)
+	if err := conf.Unmarshal(options, &opts); err != nil {
+		return err
+	}
+
+	for name, session := range opts.Sessions {
+		switch session.TargetSessionAttrs {
+		case "", targetSessionAttrsAny, targetSessionAttrsReadWrite, targetSessionAttrsReadOnly,
+			targetSessionAttrsPrimary, targetSessionAttrsStandby, targetSessionAttrsPreferStandby:
+		default:
+			return fmt.Errorf("invalid TargetSessionAttrs %q of session %q", session.TargetSessionAttrs, name)
+		}
+
+		if _, ok := sslModes[session.TLSConnect]; !ok {
+			return fmt.Errorf("invalid TLSConnect %q of session %q", session.TLSConnect, name)
+		}
+
+		if _, ok := tlsVersions[session.TLSMinVersion]; !ok {
+			return fmt.Errorf("invalid TLSMinVersion %q of session %q", session.TLSMinVersion, name)
+		}
+	}
+
+	return nil
 }
@@ -19,50 +19,127 @@ package plugin
 
 import (
 	"context"
-	"crypto/tls"
 	"database/sql"
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"git.zabbix.com/ap/plugin-support/tlsconfig"
 	"git.zabbix.com/ap/plugin-support/uri"
 	"git.zabbix.com/ap/plugin-support/zbxerr"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/jackc/pgx/v4/stdlib"
-	"github.com/omeid/go-yarn"
 )
 
-const MinSupportedPGVersion = 100000
+const (
+	MinSupportedPGVersion = 100000
+	MaxSupportedPGVersion = 179999
+)
+
+const (
+	// reconnectBackoffMin is a time to wait before the next connection attempt after the first failed one.
+	reconnectBackoffMin = 5 * time.Second
+	// reconnectBackoffMax is a limit of the exponentially growing time between connection attempts.
+	reconnectBackoffMax = 5 * time.Minute
+)
 
 type PostgresClient interface {
 	Query(ctx context.Context, query string, args ...interface{}) (rows *sql.Rows, err error)
 	QueryByName(ctx context.Context, queryName string, args ...interface{}) (rows *sql.Rows, err error)
 	QueryRow(ctx context.Context, query string, args ...interface{}) (row *sql.Row, err error)
 	QueryRowByName(ctx context.Context, queryName string, args ...interface{}) (row *sql.Row, err error)
+	QueryBuiltin(ctx context.Context, queryName string, args ...interface{}) (rows *sql.Rows, err error)
+	QueryRowBuiltin(ctx context.Context, queryName string, args ...interface{}) (row *sql.Row, err error)
+	QueryTx(ctx context.Context, readOnly bool, settings map[string]string, fn func(rows *sql.Rows) error,
+		query string, args ...interface{}) error
+	CustomQuery(queryName string) (*customQuery, error)
 	PostgresVersion() int
+	PoolStats() sql.DBStats
+	CanceledQueries() int64
+	TempFilesCounters() *tempFilesCounters
+	ResultEncoder() resultEncoder
+	WithDatabase(dbname string) (PostgresClient, error)
 }
 
 // PGConn holds pointer to the Pool of PostgreSQL Instance.
 type PGConn struct {
-	client         *sql.DB
-	callTimeout    time.Duration
-	ctx            context.Context
-	lastTimeAccess time.Time
-	version        int
-	queryStorage   *yarn.Yarn
-	address        string
+	client          *sql.DB
+	callTimeout     time.Duration
+	ctx             context.Context
+	lastTimeAccess  time.Time
+	version         int
+	queries         *queryStore
+	address         string
+	uri             uri.URI
+	tlsOpts         tlsOptions
+	options         connOptions
+	connMgr         *ConnManager
+	canceledQueries *int64
+	connectTimeout  time.Duration
+	tempFiles       *tempFilesCounters
+	// guarded is a pool of read-only connections for overridden built-in queries, it is opened on first use.
+	guarded      *sql.DB
+	guardedMutex sync.Mutex
+}
+
+// connOptions holds settings of a connection which are not a part of its uri.
+type connOptions struct {
+	// maxOpenConns is the maximum number of open connections to the database, 0 means unlimited.
+	maxOpenConns int
+	// maxIdleConns is the maximum number of idle connections kept in the pool, 0 means none.
+	maxIdleConns int
+	// connMaxLifetime is the maximum time a connection may be reused, 0 means unlimited.
+	connMaxLifetime time.Duration
+	// connMaxIdleTime is the maximum time a connection may be idle, 0 means unlimited.
+	connMaxIdleTime time.Duration
+	// applicationName is reported by the connection to the server to identify monitoring traffic.
+	applicationName string
+	// statementTimeout, lockTimeout and idleInTransactionSessionTimeout are set as server-side runtime parameters,
+	// so the server aborts monitoring queries even if the plugin has already given up waiting for them.
+	statementTimeout                time.Duration
+	lockTimeout                     time.Duration
+	idleInTransactionSessionTimeout time.Duration
+}
+
+// runtimeParams returns PostgreSQL run-time parameters to be set at connection startup.
+func (o connOptions) runtimeParams() map[string]string {
+	params := map[string]string{
+		"application_name": o.applicationName,
+	}
+
+	timeouts := map[string]time.Duration{
+		"statement_timeout":                   o.statementTimeout,
+		"lock_timeout":                        o.lockTimeout,
+		"idle_in_transaction_session_timeout": o.idleInTransactionSessionTimeout,
+	}
+
+	for name, timeout := range timeouts {
+		if timeout > 0 {
+			params[name] = strconv.FormatInt(timeout.Milliseconds(), 10)
+		}
+	}
+
+	return params
 }
 
 var errorQueryNotFound = "query %q not found"
 
 // Query wraps pgxpool.Query.
 func (conn *PGConn) Query(ctx context.Context, query string, args ...interface{}) (rows *sql.Rows, err error) {
-	rows, err = conn.client.QueryContext(ctx, query, args...)
+	return queryContext(ctx, conn.client, query, args...)
+}
+
+func queryContext(ctx context.Context, client *sql.DB, query string,
+	args ...interface{}) (rows *sql.Rows, err error) {
+	rows, err = client.QueryContext(ctx, query, args...)
 
 	if ctxErr := ctx.Err(); ctxErr != nil {
 		err = ctxErr
@@ -71,12 +148,10 @@ func (conn *PGConn) Query(ctx context.Context, query string, args ...interface{}
 	return
 }
 
-// QueryByName executes a query from queryStorage by its name and returns a single row.
+// QueryByName executes a custom query by its name.
 func (conn *PGConn) QueryByName(ctx context.Context, queryName string, args ...interface{}) (rows *sql.Rows, err error) {
-	if sql, ok := (*conn.queryStorage).Get(queryName + sqlExt); ok {
-		normalizedSQL := strings.TrimRight(strings.TrimSpace(sql), ";")
-
-		return conn.Query(ctx, normalizedSQL, args...)
+	if q, ok := conn.queries.customQuery(queryName); ok {
+		return conn.Query(ctx, q.sql, args...)
 	}
 
 	return nil, fmt.Errorf(errorQueryNotFound, queryName)
@@ -84,7 +159,12 @@ func (conn *PGConn) QueryByName(ctx context.Context, queryName string, args ...i
 
 // QueryRow wraps pgxpool.QueryRow.
 func (conn *PGConn) QueryRow(ctx context.Context, query string, args ...interface{}) (row *sql.Row, err error) {
-	row = conn.client.QueryRowContext(ctx, query, args...)
+	return queryRowContext(ctx, conn.client, query, args...)
+}
+
+func queryRowContext(ctx context.Context, client *sql.DB, query string,
+	args ...interface{}) (row *sql.Row, err error) {
+	row = client.QueryRowContext(ctx, query, args...)
 
 	if ctxErr := ctx.Err(); ctxErr != nil {
 		err = ctxErr
@@ -93,17 +173,142 @@ func (conn *PGConn) QueryRow(ctx context.Context, query string, args ...interfac
 	return
 }
 
-// QueryRowByName executes a query from queryStorage by its name and returns a single row.
+// QueryRowByName executes a custom query by its name and returns a single row.
 func (conn *PGConn) QueryRowByName(ctx context.Context, queryName string, args ...interface{}) (row *sql.Row, err error) {
-	if sql, ok := (*conn.queryStorage).Get(queryName + sqlExt); ok {
-		normalizedSQL := strings.TrimRight(strings.TrimSpace(sql), ";")
-
-		return conn.QueryRow(ctx, normalizedSQL, args...)
+	if q, ok := conn.queries.customQuery(queryName); ok {
+		return conn.QueryRow(ctx, q.sql, args...)
 	}
 
 	return nil, fmt.Errorf(errorQueryNotFound, queryName)
 }
 
+// QueryTx executes a query in a transaction with given run-time settings, e.g. statement_timeout,
+// and passes its rows to fn. The settings are local to the transaction. A read-only transaction is always
+// rolled back, it has nothing to commit, while a read-write one is committed if the query succeeds.
+func (conn *PGConn) QueryTx(ctx context.Context, readOnly bool, settings map[string]string,
+	fn func(rows *sql.Rows) error, query string, args ...interface{}) error {
+	tx, err := conn.client.BeginTx(ctx, &sql.TxOptions{ReadOnly: readOnly})
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for name, value := range settings {
+		if _, err = tx.ExecContext(ctx, `SELECT pg_catalog.set_config($1, $2, true)`, name, value); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	if err = fn(rows); err != nil {
+		return err
+	}
+
+	if err = rows.Close(); err != nil {
+		return err
+	}
+
+	if readOnly {
+		return nil
+	}
+
+	return tx.Commit()
+}
+
+// CustomQuery returns a custom query by its name or an error telling why it is not available.
+func (conn *PGConn) CustomQuery(queryName string) (*customQuery, error) {
+	return conn.queries.lookup(queryName)
+}
+
+// QueryBuiltin executes a built-in query by its name using the variant for the server version.
+func (conn *PGConn) QueryBuiltin(ctx context.Context, queryName string, args ...interface{}) (rows *sql.Rows, err error) {
+	client, query, err := conn.builtinQuery(queryName)
+	if err != nil {
+		return nil, err
+	}
+
+	return queryContext(ctx, client, query, args...)
+}
+
+// QueryRowBuiltin executes a built-in query by its name using the variant for the server version
+// and returns a single row.
+func (conn *PGConn) QueryRowBuiltin(ctx context.Context, queryName string,
+	args ...interface{}) (row *sql.Row, err error) {
+	client, query, err := conn.builtinQuery(queryName)
+	if err != nil {
+		return nil, err
+	}
+
+	return queryRowContext(ctx, client, query, args...)
+}
+
+// builtinQuery returns a built-in query, taking into account queries overridden in the custom queries directory,
+// along with the pool to execute it. Overridden queries are guarded the same way as custom ones.
+func (conn *PGConn) builtinQuery(queryName string) (*sql.DB, string, error) {
+	query, guarded, err := conn.queries.builtinQuery(queryName, conn.version)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !guarded {
+		return conn.client, query, nil
+	}
+
+	client, err := conn.guardedClient()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return client, query, nil
+}
+
+// guardedClient returns the pool of connections whose transactions are read-only by default and whose
+// statement_timeout does not exceed CallTimeout.
+func (conn *PGConn) guardedClient() (*sql.DB, error) {
+	conn.guardedMutex.Lock()
+	defer conn.guardedMutex.Unlock()
+
+	if conn.guarded != nil {
+		return conn.guarded, nil
+	}
+
+	params := conn.options.runtimeParams()
+	params["default_transaction_read_only"] = "on"
+
+	if conn.options.statementTimeout <= 0 || conn.options.statementTimeout > conn.callTimeout {
+		params["statement_timeout"] = strconv.FormatInt(conn.callTimeout.Milliseconds(), 10)
+	}
+
+	client, err := openClient(conn.uri, conn.connectTimeout, conn.tlsOpts, conn.options, params)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.guarded = client
+
+	return client, nil
+}
+
+// close closes all pools of the connection.
+func (conn *PGConn) close() {
+	conn.client.Close()
+
+	conn.guardedMutex.Lock()
+	defer conn.guardedMutex.Unlock()
+
+	if conn.guarded != nil {
+		conn.guarded.Close()
+		conn.guarded = nil
+	}
+}
+
 // GetPostgresVersion exec SQL query to retrieve the version of PostgreSQL server we are currently connected to.
 func getPostgresVersion(ctx context.Context, conn *sql.DB) (version int, err error) {
 	err = conn.QueryRowContext(ctx, `select current_setting('server_version_num');`).Scan(&version)
@@ -116,6 +321,150 @@ func (conn *PGConn) PostgresVersion() int {
 	return conn.version
 }
 
+// WithDatabase returns a connection to another database of the same server using the same credentials
+// and TLS settings.
+func (conn *PGConn) WithDatabase(dbname string) (PostgresClient, error) {
+	if conn.connMgr == nil {
+		return nil, errors.New("connection is not managed by a connection manager")
+	}
+
+	addr := baseURI(conn.uri)
+	if hosts := conn.uri.GetParam("hosts"); hosts != "" {
+		addr += "," + hosts
+	}
+
+	u, err := uri.NewWithCreds(connURI(addr, dbname, conn.uri.GetParam("target_session_attrs")),
+		conn.uri.User(), conn.uri.Password(), uriDefaults)
+	if err != nil {
+		return nil, err
+	}
+
+	dbConn, err := conn.connMgr.GetConnection(*u, conn.tlsOpts, conn.options)
+	if err != nil {
+		return nil, err
+	}
+
+	return dbConn, nil
+}
+
+// PoolStats returns statistics of the connection pool.
+func (conn *PGConn) PoolStats() sql.DBStats {
+	return conn.client.Stats()
+}
+
+// CanceledQueries returns the number of queries canceled on the server because their call timed out.
+func (conn *PGConn) CanceledQueries() int64 {
+	if conn.canceledQueries == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(conn.canceledQueries)
+}
+
+// ResultEncoder returns an encoder of results with the numeric precision configured for the plugin.
+func (conn *PGConn) ResultEncoder() resultEncoder {
+	return resultEncoder{numericPrecision: conn.queries.numericPrecision()}
+}
+
+// TempFilesCounters returns the counters of the previous pgsql.tempfiles poll of the connection. They are
+// forgotten together with the connection when it is closed.
+func (conn *PGConn) TempFilesCounters() *tempFilesCounters {
+	if conn.tempFiles == nil {
+		return &tempFilesCounters{}
+	}
+
+	return conn.tempFiles
+}
+
+// countTimeout counts a call failed with a given error if it timed out. pgconn sends a cancel request
+// for the backend when the context of a query is done, so such a query does not keep running on the server.
+func (conn *PGConn) countTimeout(ctx context.Context, err error) {
+	if conn.canceledQueries == nil || err == nil {
+		return
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		atomic.AddInt64(conn.canceledQueries, 1)
+	}
+}
+
+// baseURI returns the address part of a given uri without credentials and query parameters.
+func baseURI(u uri.URI) string {
+	if u.Scheme() == "unix" {
+		return u.Scheme() + ":" + u.Addr()
+	}
+
+	return u.Scheme() + "://" + net.JoinHostPort(u.Host(), u.Port())
+}
+
+// connURI returns a raw uri of a connection to a given database at a single or multi-host address,
+// where hosts are separated by commas. The hosts following the first one and target_session_attrs are kept
+// as query parameters, so connections to different clusters or with different node requirements are
+// managed separately.
+func connURI(addr, dbname, targetSessionAttrs string) string {
+	hosts := strings.Split(addr, ",")
+	for i := range hosts {
+		hosts[i] = strings.TrimSpace(hosts[i])
+	}
+
+	rawURI := hosts[0] + "?dbname=" + url.QueryEscape(dbname)
+
+	if len(hosts) > 1 {
+		rawURI += "&hosts=" + url.QueryEscape(strings.Join(hosts[1:], ","))
+	}
+
+	if targetSessionAttrs != "" && targetSessionAttrs != targetSessionAttrsAny {
+		rawURI += "&target_session_attrs=" + url.QueryEscape(targetSessionAttrs)
+	}
+
+	return rawURI
+}
+
+// hostPort returns a host and a port of a given uri in terms of libpq,
+// where a Unix-socket is set by its directory and the port number taken from its extension.
+func hostPort(u uri.URI) (host, port string, err error) {
+	if u.Scheme() != "unix" {
+		return u.Host(), u.Port(), nil
+	}
+
+	socket := u.Addr()
+
+	ext := filepath.Ext(filepath.Base(socket))
+	if len(ext) <= 1 {
+		return "", "", fmt.Errorf("incorrect socket: %q", socket)
+	}
+
+	return filepath.Dir(socket), ext[1:], nil
+}
+
+// dsnHosts returns lists of hosts and ports of a given uri including its additional hosts.
+func dsnHosts(u uri.URI) (hosts, ports []string, err error) {
+	addrs := []uri.URI{u}
+
+	if extra := u.GetParam("hosts"); extra != "" {
+		for _, addr := range strings.Split(extra, ",") {
+			hu, err := uri.New(addr, uriDefaults)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			addrs = append(addrs, *hu)
+		}
+	}
+
+	for _, addr := range addrs {
+		host, port, err := hostPort(addr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		hosts = append(hosts, host)
+		ports = append(ports, port)
+	}
+
+	return hosts, ports, nil
+}
+
 // updateAccessTime updates the last time a connection was accessed.
 func (conn *PGConn) updateAccessTime() {
 	conn.lastTimeAccess = time.Now()
@@ -123,29 +472,91 @@ func (conn *PGConn) updateAccessTime() {
 
 // ConnManager is a thread-safe structure for manage connections.
 type ConnManager struct {
-	sync.Mutex
 	connMutex      sync.Mutex
-	connections    map[uri.URI]*PGConn
+	connections    map[connKey]*PGConn
+	pending        map[connKey]*connRequest
+	failures       map[connKey]*connFailure
 	keepAlive      time.Duration
 	connectTimeout time.Duration
 	callTimeout    time.Duration
 	Destroy        context.CancelFunc
-	queryStorage   yarn.Yarn
+	queries        *queryStore
+	connect        connectFunc
+	now            func() time.Time
+	stats          connStats
+}
+
+// connKey identifies a managed connection. Connections to the same uri with different TLS settings are kept
+// apart, so a request demanding stricter verification never reuses a connection established with weaker settings.
+// Connection options are a part of the key as well, so sessions with the same uri but different pool settings,
+// timeouts or application names get connections of their own.
+type connKey struct {
+	uri  uri.URI
+	tls  tlsOptions
+	opts connOptions
+}
+
+// connStats holds counters of connection events. It is guarded by connMutex.
+type connStats struct {
+	created          int64
+	failed           int64
+	closedUnused     int64
+	closedMismatched int64
+	closedOutdated   int64
+}
+
+// connectFunc establishes a new connection with given credentials.
+type connectFunc func(uri uri.URI, tlsOpts tlsOptions, opts connOptions) (*PGConn, error)
+
+// connRequest is an in-flight attempt to establish a connection, shared by all callers requesting the same uri.
+type connRequest struct {
+	done chan struct{}
+	conn *PGConn
+	err  error
+}
+
+// connFailure tracks consecutive failed connection attempts to an uri. While it exists the circuit is open:
+// requests fail immediately with the last connection error until retryAt, after which a single request
+// is let through to probe the instance (half-open state). A successful probe removes the failure.
+type connFailure struct {
+	count       int
+	err         error
+	retryAt     time.Time
+	lastRequest time.Time
+}
+
+// backoff returns a time to wait before the next connection attempt after a given number of consecutive failures.
+func backoff(failures int) time.Duration {
+	d := reconnectBackoffMin
+
+	for i := 1; i < failures && d < reconnectBackoffMax; i++ {
+		d *= 2
+	}
+
+	if d > reconnectBackoffMax {
+		d = reconnectBackoffMax
+	}
+
+	return d
 }
 
 // NewConnManager initializes connManager structure and runs Go Routine that watches for unused connections.
 func NewConnManager(keepAlive, connectTimeout, callTimeout,
-	hkInterval time.Duration, queryStorage yarn.Yarn) *ConnManager {
+	hkInterval time.Duration, queries *queryStore) *ConnManager {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	connMgr := &ConnManager{
-		connections:    make(map[uri.URI]*PGConn),
+		connections:    make(map[connKey]*PGConn),
+		pending:        make(map[connKey]*connRequest),
+		failures:       make(map[connKey]*connFailure),
 		keepAlive:      keepAlive,
 		connectTimeout: connectTimeout,
 		callTimeout:    callTimeout,
 		Destroy:        cancel, // Destroy stops originated goroutines and closes connections.
-		queryStorage:   queryStorage,
+		queries:        queries,
+		now:            time.Now,
 	}
+	connMgr.connect = connMgr.create
 
 	go connMgr.housekeeper(ctx, hkInterval)
 
@@ -157,21 +568,113 @@ func (c *ConnManager) closeUnused() {
 	c.connMutex.Lock()
 	defer c.connMutex.Unlock()
 
-	for uri, conn := range c.connections {
+	for key, conn := range c.connections {
 		if time.Since(conn.lastTimeAccess) > c.keepAlive {
-			conn.client.Close()
-			delete(c.connections, uri)
-			Impl.Debugf("[%s] Closed unused connection: %s", Name, uri.Addr())
+			conn.close()
+			delete(c.connections, key)
+			c.stats.closedUnused++
+			Impl.Debugf("[%s] Closed unused connection: %s", Name, key.uri.Addr())
+		}
+	}
+
+	for key, failure := range c.failures {
+		if _, ok := c.pending[key]; !ok && time.Since(failure.lastRequest) > c.keepAlive {
+			delete(c.failures, key)
+		}
+	}
+}
+
+// closeByCredentials closes each connection established with given credentials,
+// e.g. after the password of a session was rotated.
+func (c *ConnManager) closeByCredentials(user, password string) {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	for key, conn := range c.connections {
+		if key.uri.User() == user && key.uri.Password() == password {
+			conn.close()
+			delete(c.connections, key)
+			c.stats.closedOutdated++
+			Impl.Debugf("[%s] Closed connection with outdated credentials: %s", Name, key.uri.Addr())
 		}
 	}
 }
 
+// closeMismatched closes each connection whose server no longer satisfies its target_session_attrs,
+// e.g. the primary was demoted after a failover, so the next request connects to a suitable host again.
+// Connections which cannot be checked are closed as well, since the server has probably gone.
+func (c *ConnManager) closeMismatched(ctx context.Context) {
+	c.connMutex.Lock()
+
+	conns := make(map[connKey]*PGConn)
+
+	for key, conn := range c.connections {
+		if attrs := key.uri.GetParam("target_session_attrs"); attrs != "" && attrs != targetSessionAttrsPreferStandby {
+			conns[key] = conn
+		}
+	}
+
+	c.connMutex.Unlock()
+
+	for key, conn := range conns {
+		uri := key.uri
+		ctxTimeout, cancel := context.WithTimeout(ctx, c.callTimeout)
+		ok, err := matchTargetSessionAttrs(ctxTimeout, conn.client, uri.GetParam("target_session_attrs"))
+		cancel()
+
+		if ok {
+			continue
+		}
+
+		c.connMutex.Lock()
+
+		if c.connections[key] == conn {
+			conn.close()
+			delete(c.connections, key)
+			c.stats.closedMismatched++
+
+			if err != nil {
+				Impl.Debugf("[%s] Closed connection %s, cannot check server state: %s", Name, uri.Addr(), err.Error())
+			} else {
+				Impl.Debugf("[%s] Closed connection %s, server does not satisfy target_session_attrs=%s anymore",
+					Name, uri.Addr(), uri.GetParam("target_session_attrs"))
+			}
+		}
+
+		c.connMutex.Unlock()
+	}
+}
+
+// matchTargetSessionAttrs checks whether a server satisfies given target_session_attrs.
+func matchTargetSessionAttrs(ctx context.Context, client *sql.DB, attrs string) (bool, error) {
+	var inRecovery, readOnly bool
+
+	err := client.QueryRowContext(ctx,
+		`SELECT pg_is_in_recovery(), current_setting('transaction_read_only') = 'on'`).Scan(&inRecovery, &readOnly)
+	if err != nil {
+		return false, err
+	}
+
+	switch attrs {
+	case targetSessionAttrsReadWrite:
+		return !readOnly, nil
+	case targetSessionAttrsReadOnly:
+		return readOnly, nil
+	case targetSessionAttrsPrimary:
+		return !inRecovery, nil
+	case targetSessionAttrsStandby:
+		return inRecovery, nil
+	}
+
+	return true, nil
+}
+
 // closeAll closes all existed connections.
 func (c *ConnManager) closeAll() {
 	c.connMutex.Lock()
-	for uri, conn := range c.connections {
-		conn.client.Close()
-		delete(c.connections, uri)
+	for key, conn := range c.connections {
+		conn.close()
+		delete(c.connections, key)
 	}
 	c.connMutex.Unlock()
 }
@@ -189,121 +692,147 @@ func (c *ConnManager) housekeeper(ctx context.Context, interval time.Duration) {
 			return
 		case <-ticker.C:
 			c.closeUnused()
+			c.closeMismatched(ctx)
 		}
 	}
 }
 
 // create creates a new connection with given credentials.
-func (c *ConnManager) create(uri uri.URI, details tlsconfig.Details) (*PGConn, error) {
-	c.connMutex.Lock()
-	defer c.connMutex.Unlock()
+// It does not register the connection, so it can be called without holding any lock.
+func (c *ConnManager) create(uri uri.URI, tlsOpts tlsOptions, opts connOptions) (*PGConn, error) {
+	ctx := context.Background()
 
-	if _, ok := c.connections[uri]; ok {
-		// Should never happen.
-		panic("connection already exists")
+	client, err := openClient(uri, c.connectTimeout, tlsOpts, opts, opts.runtimeParams())
+	if err != nil {
+		return nil, err
 	}
 
-	ctx := context.Background()
-
-	host := uri.Host()
-	port := uri.Port()
+	serverVersion, err := getPostgresVersion(ctx, client)
+	if err != nil {
+		client.Close()
 
-	if uri.Scheme() == "unix" {
-		socket := uri.Addr()
-		host = filepath.Dir(socket)
+		return nil, err
+	}
 
-		ext := filepath.Ext(filepath.Base(socket))
-		if len(ext) <= 1 {
-			return nil, fmt.Errorf("incorrect socket: %q", socket)
-		}
+	if serverVersion < MinSupportedPGVersion {
+		client.Close()
 
-		port = ext[1:]
+		return nil, fmt.Errorf("PostgreSQL version %d is not supported", serverVersion)
 	}
 
-	dbname, err := url.QueryUnescape(uri.GetParam("dbname"))
-	if err != nil {
-		return nil, err
+	conn := &PGConn{
+		client:          client,
+		callTimeout:     c.callTimeout,
+		version:         serverVersion,
+		lastTimeAccess:  time.Now(),
+		ctx:             ctx,
+		queries:         c.queries,
+		address:         uri.Addr(),
+		uri:             uri,
+		tlsOpts:         tlsOpts,
+		options:         opts,
+		connMgr:         c,
+		canceledQueries: new(int64),
+		connectTimeout:  c.connectTimeout,
+		tempFiles:       &tempFilesCounters{},
 	}
 
-	dsn := fmt.Sprintf("host=%s port=%s dbname=%s user=%s",
-		host, port, dbname, uri.User())
+	Impl.Debugf("[%s] Created new connection: %s", Name, uri.Addr())
 
-	if uri.Password() != "" {
-		dsn += " password=" + uri.Password()
-	}
+	return conn, nil
+}
 
-	client, err := createTLSClient(dsn, c.connectTimeout, details)
+// openClient opens a pool of connections with given credentials and run-time parameters.
+func openClient(uri uri.URI, connectTimeout time.Duration, tlsOpts tlsOptions, opts connOptions,
+	runtimeParams map[string]string) (*sql.DB, error) {
+	hosts, ports, err := dsnHosts(uri)
 	if err != nil {
 		return nil, err
 	}
 
-	serverVersion, err := getPostgresVersion(ctx, client)
+	dbname, err := url.QueryUnescape(uri.GetParam("dbname"))
 	if err != nil {
 		return nil, err
 	}
 
-	if serverVersion < MinSupportedPGVersion {
-		return nil, fmt.Errorf("PostgreSQL version %d is not supported", serverVersion)
+	attrs := uri.GetParam("target_session_attrs")
+	if attrs == "" {
+		attrs = targetSessionAttrsAny
 	}
 
-	c.connections[uri] = &PGConn{
-		client:         client,
-		callTimeout:    c.callTimeout,
-		version:        serverVersion,
-		lastTimeAccess: time.Now(),
-		ctx:            ctx,
-		queryStorage:   &c.queryStorage,
-		address:        uri.Addr(),
+	// All settings are resolved by the plugin, so they are set explicitly to prevent pgconn from taking
+	// them from the environment or a password file. TLS is configured for every host explicitly as well,
+	// so sslmode must not add fallbacks of its own.
+	dsn := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s passfile='' sslmode=disable "+
+		"target_session_attrs=%s", strings.Join(hosts, ","), strings.Join(ports, ","), dsnValue(dbname),
+		dsnValue(uri.User()), dsnValue(uri.Password()), attrs)
+
+	client, err := createTLSClient(dsn, connectTimeout, tlsOpts, runtimeParams)
+	if err != nil {
+		return nil, err
 	}
 
-	Impl.Debugf("[%s] Created new connection: %s", Name, uri.Addr())
+	client.SetMaxOpenConns(opts.maxOpenConns)
+	client.SetMaxIdleConns(opts.maxIdleConns)
+	client.SetConnMaxLifetime(opts.connMaxLifetime)
+	client.SetConnMaxIdleTime(opts.connMaxIdleTime)
 
-	return c.connections[uri], nil
+	return client, nil
 }
 
-func createTLSClient(dsn string, timeout time.Duration, details tlsconfig.Details) (*sql.DB, error) {
+func createTLSClient(dsn string, timeout time.Duration, tlsOpts tlsOptions,
+	runtimeParams map[string]string) (*sql.DB, error) {
 	config, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
 		return nil, err
 	}
 
+	for name, value := range runtimeParams {
+		config.ConnConfig.RuntimeParams[name] = value
+	}
+
 	config.ConnConfig.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
 		d := net.Dialer{}
 		ctxTimeout, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
 
-		conn, err := d.DialContext(ctxTimeout, network, addr)
-
-		return conn, err
+		return d.DialContext(ctxTimeout, network, addr)
 	}
 
-	config.ConnConfig.TLSConfig, err = getTLSConfig(details)
-	if err != nil {
-		return nil, err
-	}
+	// The connection string has a single fallback per host, each of them is expanded to the TLS configurations
+	// to try according to sslmode.
+	hosts := append([]*pgconn.FallbackConfig{{Host: config.ConnConfig.Host, Port: config.ConnConfig.Port}},
+		config.ConnConfig.Fallbacks...)
+	fallbacks := make([]*pgconn.FallbackConfig, 0, len(hosts))
 
-	return stdlib.OpenDB(*config.ConnConfig), nil
-}
+	for _, host := range hosts {
+		tlsConfigs, err := tlsOpts.configs(host.Host)
+		if err != nil {
+			return nil, err
+		}
 
-func getTLSConfig(details tlsconfig.Details) (*tls.Config, error) {
-	switch details.TlsConnect {
-	case "required":
-		return &tls.Config{InsecureSkipVerify: true}, nil
-	case "verify_ca":
-		return tlsconfig.CreateConfig(details, true)
-	case "verify_full":
-		return tlsconfig.CreateConfig(details, false)
+		for _, tlsConfig := range tlsConfigs {
+			fallbacks = append(fallbacks, &pgconn.FallbackConfig{Host: host.Host, Port: host.Port, TLSConfig: tlsConfig})
+		}
 	}
 
-	return nil, nil
+	config.ConnConfig.Host = fallbacks[0].Host
+	config.ConnConfig.Port = fallbacks[0].Port
+	config.ConnConfig.TLSConfig = fallbacks[0].TLSConfig
+	config.ConnConfig.Fallbacks = fallbacks[1:]
+
+	return stdlib.OpenDB(*config.ConnConfig), nil
 }
 
-// get returns a connection with given uri if it exists and also updates lastTimeAccess, otherwise returns nil.
-func (c *ConnManager) get(uri uri.URI) *PGConn {
-	c.connMutex.Lock()
-	defer c.connMutex.Unlock()
+// dsnValue quotes a value of a connection string parameter.
+func dsnValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
 
-	if conn, ok := c.connections[uri]; ok {
+// get returns a connection with given key if it exists and also updates lastTimeAccess, otherwise returns nil.
+// The caller must hold connMutex.
+func (c *ConnManager) get(key connKey) *PGConn {
+	if conn, ok := c.connections[key]; ok {
 		conn.updateAccessTime()
 		return conn
 	}
@@ -312,19 +841,158 @@ func (c *ConnManager) get(uri uri.URI) *PGConn {
 }
 
 // GetConnection returns an existing connection or creates a new one.
-func (c *ConnManager) GetConnection(uri uri.URI, details tlsconfig.Details) (conn *PGConn, err error) {
-	c.Lock()
-	defer c.Unlock()
+// Connections to different URIs are established concurrently without blocking each other,
+// while concurrent requests for the same uri, TLS settings and options share a single connection attempt.
+// After a failed attempt further attempts to the same uri are delayed with exponential backoff,
+// meanwhile the last connection error is returned without dialing.
+func (c *ConnManager) GetConnection(uri uri.URI, tlsOpts tlsOptions, opts connOptions) (*PGConn, error) {
+	key := connKey{uri: uri, tls: tlsOpts, opts: opts}
 
-	conn = c.get(uri)
+	c.connMutex.Lock()
+
+	if conn := c.get(key); conn != nil {
+		c.connMutex.Unlock()
 
-	if conn == nil {
-		conn, err = c.create(uri, details)
+		return conn, nil
 	}
 
-	if err != nil {
-		err = zbxerr.ErrorConnectionFailed.Wrap(err)
+	now := c.now()
+	req, ok := c.pending[key]
+
+	if failure, open := c.failures[key]; open {
+		failure.lastRequest = now
+
+		if ok || now.Before(failure.retryAt) {
+			err := fmt.Errorf("%w (next connection attempt in %s)",
+				failure.err, failure.retryAt.Sub(now).Round(time.Second))
+			c.connMutex.Unlock()
+
+			return nil, zbxerr.ErrorConnectionFailed.Wrap(err)
+		}
 	}
 
-	return
+	if ok {
+		c.connMutex.Unlock()
+		<-req.done
+	} else {
+		req = &connRequest{done: make(chan struct{})}
+		c.pending[key] = req
+		c.connMutex.Unlock()
+
+		req.conn, req.err = c.connect(uri, tlsOpts, opts)
+
+		c.connMutex.Lock()
+		delete(c.pending, key)
+
+		if req.err == nil {
+			c.connections[key] = req.conn
+			c.stats.created++
+			delete(c.failures, key)
+		} else {
+			c.registerFailure(key, req.err)
+		}
+		c.connMutex.Unlock()
+
+		close(req.done)
+	}
+
+	if req.err != nil {
+		return nil, zbxerr.ErrorConnectionFailed.Wrap(req.err)
+	}
+
+	return req.conn, nil
+}
+
+// connInfo describes a live connection without its credentials.
+type connInfo struct {
+	URI        string `json:"uri"`
+	Database   string `json:"database"`
+	User       string `json:"user"`
+	LastAccess int64  `json:"last_access"`
+}
+
+// connManagerStats is a snapshot of the connection manager's state.
+type connManagerStats struct {
+	Live             int        `json:"live_connections"`
+	Created          int64      `json:"created"`
+	Failed           int64      `json:"failed"`
+	ClosedUnused     int64      `json:"closed_unused"`
+	ClosedMismatched int64      `json:"closed_mismatched"`
+	ClosedOutdated   int64      `json:"closed_outdated"`
+	Connections      []connInfo `json:"connections"`
+	CustomQueries    int        `json:"custom_queries"`
+	CustomQueriesLen int        `json:"custom_queries_size"`
+}
+
+// Stats returns a snapshot of the connection manager's state, connections are sorted by uri.
+func (c *ConnManager) Stats() connManagerStats {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	stats := connManagerStats{
+		Live:             len(c.connections),
+		Created:          c.stats.created,
+		Failed:           c.stats.failed,
+		ClosedUnused:     c.stats.closedUnused,
+		ClosedMismatched: c.stats.closedMismatched,
+		ClosedOutdated:   c.stats.closedOutdated,
+		Connections:      make([]connInfo, 0, len(c.connections)),
+	}
+
+	for key, conn := range c.connections {
+		uri := key.uri
+		addr := baseURI(uri)
+		if hosts := uri.GetParam("hosts"); hosts != "" {
+			addr += "," + hosts
+		}
+
+		stats.Connections = append(stats.Connections, connInfo{
+			URI:        addr,
+			Database:   uri.GetParam("dbname"),
+			User:       uri.User(),
+			LastAccess: conn.lastTimeAccess.Unix(),
+		})
+	}
+
+	sort.Slice(stats.Connections, func(i, j int) bool {
+		a, b := stats.Connections[i], stats.Connections[j]
+		if a.URI != b.URI {
+			return a.URI < b.URI
+		}
+
+		if a.Database != b.Database {
+			return a.Database < b.Database
+		}
+
+		return a.User < b.User
+	})
+
+	for _, q := range c.queries.customQueryList() {
+		stats.CustomQueries++
+		stats.CustomQueriesLen += len(q.sql)
+	}
+
+	return stats
+}
+
+// registerFailure opens the circuit for a given connection key or extends it after a failed probe.
+// The caller must hold connMutex.
+func (c *ConnManager) registerFailure(key connKey, err error) {
+	now := c.now()
+
+	failure, ok := c.failures[key]
+	if !ok {
+		failure = &connFailure{}
+		c.failures[key] = failure
+	}
+
+	c.stats.failed++
+
+	failure.count++
+	failure.err = err
+	failure.lastRequest = now
+	failure.retryAt = now.Add(backoff(failure.count))
+
+	Impl.Debugf("[%s] Cannot connect to %s (%d consecutive failures), next attempt in %s: %s",
+		Name, key.uri.Addr(), failure.count, failure.retryAt.Sub(now), err.Error())
 }
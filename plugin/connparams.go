@@ -0,0 +1,362 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.zabbix.com/ap/plugin-support/uri"
+	"github.com/jackc/pgpassfile"
+	"github.com/jackc/pgservicefile"
+)
+
+// envSettingNames maps the supported PG* environment variables to the libpq parameters they set.
+var envSettingNames = map[string]string{
+	"PGHOST":                  "host",
+	"PGPORT":                  "port",
+	"PGDATABASE":              "dbname",
+	"PGUSER":                  "user",
+	"PGPASSWORD":              "password",
+	"PGPASSFILE":              "passfile",
+	"PGSERVICE":               "service",
+	"PGSERVICEFILE":           "servicefile",
+	"PGSYSCONFDIR":            "sysconfdir",
+	"PGSSLMODE":               "sslmode",
+	"PGSSLROOTCERT":           "sslrootcert",
+	"PGSSLCERT":               "sslcert",
+	"PGSSLKEY":                "sslkey",
+	"PGSSLCRL":                "sslcrl",
+	"PGSSLMINPROTOCOLVERSION": "ssl_min_protocol_version",
+	"PGTARGETSESSIONATTRS":    "target_session_attrs",
+}
+
+// libpqTLSParams maps libpq TLS parameters to the corresponding connection parameters of the plugin.
+var libpqTLSParams = map[string]string{
+	"sslmode":                  "TLSConnect",
+	"sslrootcert":              "TLSCAFile",
+	"sslcert":                  "TLSCertFile",
+	"sslkey":                   "TLSKeyFile",
+	"sslpassword":              "TLSKeyPassword",
+	"sslcrl":                   "TLSCRLFile",
+	"ssl_min_protocol_version": "TLSMinVersion",
+}
+
+// envSettings returns libpq parameters set by the PG* environment variables.
+func envSettings() map[string]string {
+	settings := make(map[string]string)
+
+	for env, name := range envSettingNames {
+		if value := os.Getenv(env); value != "" {
+			settings[name] = value
+		}
+	}
+
+	return settings
+}
+
+// serviceSettings returns libpq parameters of a given service.
+// The service is looked up in the service file set by servicefile, or in ~/.pg_service.conf,
+// and then in pg_service.conf of the sysconfdir directory, the same way as libpq does.
+func serviceSettings(name, servicefile, sysconfdir string) (map[string]string, error) {
+	var paths []string
+
+	if servicefile != "" {
+		paths = append(paths, servicefile)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".pg_service.conf"))
+	}
+
+	if sysconfdir != "" {
+		paths = append(paths, filepath.Join(sysconfdir, "pg_service.conf"))
+	}
+
+	for _, path := range paths {
+		parsed, err := readConnFile(path, func(path string) (interface{}, error) {
+			return pgservicefile.ReadServicefile(path)
+		})
+		if err != nil {
+			continue
+		}
+
+		if service, err := parsed.(*pgservicefile.Servicefile).GetService(name); err == nil {
+			return service.Settings, nil
+		}
+	}
+
+	return nil, fmt.Errorf("service %q not found in %s", name, strings.Join(paths, ", "))
+}
+
+// connFile is a parsed version of a service or password file, the file is considered unchanged while its
+// modification time and size stay the same, like files of the custom queries directory.
+type connFile struct {
+	modTime time.Time
+	size    int64
+	parsed  interface{}
+}
+
+// connFiles caches service and password files by their paths, so they are not parsed on every poll.
+var connFiles = struct {
+	sync.Mutex
+	files map[string]connFile
+}{files: make(map[string]connFile)}
+
+// readConnFile returns a file parsed by a given function, the file is parsed again only if it has changed
+// since it was parsed last time.
+func readConnFile(path string, parse func(path string) (interface{}, error)) (interface{}, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		connFiles.Lock()
+		delete(connFiles.files, path)
+		connFiles.Unlock()
+
+		return nil, err
+	}
+
+	connFiles.Lock()
+	f, ok := connFiles.files[path]
+	connFiles.Unlock()
+
+	if ok && f.modTime.Equal(info.ModTime()) && f.size == info.Size() {
+		return f.parsed, nil
+	}
+
+	parsed, err := parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	connFiles.Lock()
+	connFiles.files[path] = connFile{modTime: info.ModTime(), size: info.Size(), parsed: parsed}
+	connFiles.Unlock()
+
+	return parsed, nil
+}
+
+// libpqURI returns a single or multi-host URI for libpq host and port parameters,
+// where a host starting with a slash is a Unix-socket directory.
+func libpqURI(host, port string) string {
+	hosts := strings.Split(host, ",")
+	ports := strings.Split(port, ",")
+	addrs := make([]string, 0, len(hosts))
+
+	for i, h := range hosts {
+		p := ports[0]
+		if i < len(ports) {
+			p = ports[i]
+		}
+
+		if p == "" {
+			p = uriDefaults.Port
+		}
+
+		if h == "" {
+			h = "localhost"
+		}
+
+		if strings.HasPrefix(h, "/") {
+			addrs = append(addrs, "unix:"+filepath.Join(h, ".s.PGSQL."+p))
+		} else {
+			addrs = append(addrs, "tcp://"+net.JoinHostPort(h, p))
+		}
+	}
+
+	return strings.Join(addrs, ",")
+}
+
+// passfilePassword looks up a password in a password file for the first host of a given URI.
+// An empty string is returned if the file cannot be read or has no matching entry.
+func passfilePassword(path, rawURI, database, user string) string {
+	parsed, err := readConnFile(path, func(path string) (interface{}, error) {
+		return pgpassfile.ReadPassfile(path)
+	})
+	if err != nil {
+		return ""
+	}
+
+	pf := parsed.(*pgpassfile.Passfile)
+
+	u, err := uri.New(strings.TrimSpace(strings.Split(rawURI, ",")[0]), uriDefaults)
+	if err != nil {
+		return ""
+	}
+
+	host, port, err := hostPort(*u)
+	if err != nil {
+		return ""
+	}
+
+	if u.Scheme() == "unix" {
+		host = "localhost"
+	}
+
+	return pf.FindPassword(host, port, database, user)
+}
+
+// uriTLSParams returns TLS parameters set by libpq names in the query of a given URI
+// and the URI without the query.
+func uriTLSParams(rawURI string) (string, map[string]string, error) {
+	i := strings.Index(rawURI, "?")
+	if i < 0 {
+		return rawURI, nil, nil
+	}
+
+	query, err := url.ParseQuery(rawURI[i+1:])
+	if err != nil {
+		return "", nil, err
+	}
+
+	params := make(map[string]string)
+
+	for name := range query {
+		param, ok := libpqTLSParams[name]
+		if !ok {
+			return "", nil, fmt.Errorf("unsupported URI parameter %q", name)
+		}
+
+		params[param] = query.Get(name)
+	}
+
+	return rawURI[:i], params, nil
+}
+
+// resolveConnParams completes connection parameters, which are not set explicitly by a session or key parameters,
+// with settings of a service from pg_service.conf and, if enabled, of the PG* environment variables.
+// The explicit parameters take precedence over the service, which takes precedence over the environment.
+// If there is still no password, it is looked up in a password file with .pgpass semantics.
+// TLS parameters set in the query of the URI are accepted only if it is enabled by AllowKeyTLSParams,
+// they take precedence over all other settings.
+func (p *Plugin) resolveConnParams(rawParams []string, params map[string]string) error {
+	session, isSession := p.options.Sessions[params["sessionName"]]
+
+	rawURI, uriParams, err := uriTLSParams(params["URI"])
+	if err != nil {
+		return err
+	}
+
+	if uriParams != nil && p.options.AllowKeyTLSParams != 1 {
+		return errors.New("TLS parameters in URI are not allowed, see the AllowKeyTLSParams option")
+	}
+
+	params["URI"] = rawURI
+
+	var explicit map[string]bool
+
+	if isSession {
+		params["TLSKeyPassword"] = session.TLSKeyPassword
+		params["TLSCRLFile"] = session.TLSCRLFile
+		params["TLSMinVersion"] = session.TLSMinVersion
+		params["TLSServerName"] = session.TLSServerName
+		params["TLSUseSystemCA"] = strconv.Itoa(session.TLSUseSystemCA)
+
+		explicit = map[string]bool{
+			"URI":            session.URI != "",
+			"User":           session.User != "",
+			"Password":       session.Password != "",
+			"Database":       session.Database != "",
+			"TLSConnect":     session.TLSConnect != "",
+			"TLSCAFile":      session.TLSCAFile != "",
+			"TLSCertFile":    session.TLSCertFile != "",
+			"TLSKeyFile":     session.TLSKeyFile != "",
+			"TLSKeyPassword": session.TLSKeyPassword != "",
+			"TLSCRLFile":     session.TLSCRLFile != "",
+			"TLSMinVersion":  session.TLSMinVersion != "",
+		}
+	} else {
+		explicit = make(map[string]bool)
+
+		for i, name := range []string{"URI", "User", "Password", "Database"} {
+			explicit[name] = len(rawParams) > i && rawParams[i] != ""
+		}
+	}
+
+	settings := make(map[string]string)
+	if p.options.UseEnvironment == 1 {
+		settings = envSettings()
+	}
+
+	service := session.Service
+	if service == "" {
+		service = settings["service"]
+	}
+
+	if service != "" {
+		serviceSettings, err := serviceSettings(service, settings["servicefile"], settings["sysconfdir"])
+		if err != nil {
+			return err
+		}
+
+		for name, value := range serviceSettings {
+			settings[name] = value
+		}
+	}
+
+	set := func(param, value string) {
+		if !explicit[param] && value != "" {
+			params[param] = value
+		}
+	}
+
+	if settings["host"] != "" || settings["port"] != "" {
+		set("URI", libpqURI(settings["host"], settings["port"]))
+	}
+
+	set("User", settings["user"])
+	set("Password", settings["password"])
+	set("Database", settings["dbname"])
+
+	for name, param := range libpqTLSParams {
+		set(param, settings[name])
+	}
+
+	for param, value := range uriParams {
+		params[param] = value
+	}
+
+	params["TargetSessionAttrs"] = session.TargetSessionAttrs
+	if params["TargetSessionAttrs"] == "" {
+		params["TargetSessionAttrs"] = settings["target_session_attrs"]
+	}
+
+	if params["Password"] == "" {
+		passfile := session.PassFile
+		if passfile == "" {
+			passfile = settings["passfile"]
+		}
+
+		if passfile == "" && p.options.UseEnvironment == 1 {
+			if home, err := os.UserHomeDir(); err == nil {
+				passfile = filepath.Join(home, ".pgpass")
+			}
+		}
+
+		if passfile != "" {
+			params["Password"] = passfilePassword(passfile, params["URI"], params["Database"], params["User"])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,243 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+func Test_libpqURI(t *testing.T) {
+	tests := []struct {
+		name string
+		host string
+		port string
+		want string
+	}{
+		{"host", "pg1", "", "tcp://pg1:5432"},
+		{"port", "", "5433", "tcp://localhost:5433"},
+		{"socket", "/var/run/postgresql", "5432", "unix:/var/run/postgresql/.s.PGSQL.5432"},
+		{"multiple hosts", "pg1,pg2", "5432,5433", "tcp://pg1:5432,tcp://pg2:5433"},
+		{"single port for multiple hosts", "pg1,pg2", "5433", "tcp://pg1:5433,tcp://pg2:5433"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := libpqURI(tt.host, tt.port); got != tt.want {
+				t.Errorf("libpqURI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dsnValue(t *testing.T) {
+	for _, password := range []string{"", "secret", `it's a \ secret`} {
+		config, err := pgconn.ParseConfig("host=localhost user=postgres password=" + dsnValue(password))
+		if err != nil {
+			t.Fatalf("pgconn.ParseConfig() error = %v", err)
+		}
+
+		if config.Password != password {
+			t.Errorf("dsnValue() parsed as %q, want %q", config.Password, password)
+		}
+	}
+}
+
+func TestPlugin_resolveConnParams(t *testing.T) {
+	dir := t.TempDir()
+
+	serviceFile := filepath.Join(dir, "pg_service.conf")
+	if err := os.WriteFile(serviceFile, []byte(
+		"[prod]\nhost=pg1,pg2\nport=5433\ndbname=app\nuser=monitor\nsslmode=verify-full\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	passFile := filepath.Join(dir, "pgpass")
+	if err := os.WriteFile(passFile, []byte("pg1:5433:*:monitor:secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PGSERVICEFILE", serviceFile)
+	t.Setenv("PGUSER", "envuser")
+
+	tests := []struct {
+		name           string
+		useEnvironment int
+		allowKeyTLS    int
+		session        Session
+		rawParams      []string
+		params         map[string]string
+		want           map[string]string
+		wantErr        bool
+	}{
+		{
+			"service with explicit database",
+			1,
+			0,
+			Session{Service: "prod", Database: "postgres", PassFile: passFile},
+			[]string{"Prod"},
+			map[string]string{"sessionName": "Prod", "URI": "tcp://localhost:5432", "User": "postgres",
+				"Password": "", "Database": "postgres", "TLSConnect": ""},
+			map[string]string{"sessionName": "Prod", "URI": "tcp://pg1:5433,tcp://pg2:5433", "User": "monitor",
+				"Password": "secret", "Database": "postgres", "TLSConnect": "verify-full", "TLSKeyPassword": "",
+				"TLSCRLFile": "", "TLSMinVersion": "", "TLSServerName": "", "TLSUseSystemCA": "0",
+				"TargetSessionAttrs": ""},
+			false,
+		},
+		{
+			"environment is disabled",
+			0,
+			0,
+			Session{},
+			[]string{},
+			map[string]string{"URI": "tcp://localhost:5432", "User": "postgres", "Password": "", "Database": "postgres"},
+			map[string]string{"URI": "tcp://localhost:5432", "User": "postgres", "Password": "", "Database": "postgres",
+				"TargetSessionAttrs": ""},
+			false,
+		},
+		{
+			"environment with explicit user",
+			1,
+			0,
+			Session{},
+			[]string{"tcp://localhost:5432", "zabbix"},
+			map[string]string{"URI": "tcp://localhost:5432", "User": "zabbix", "Password": "", "Database": "postgres"},
+			map[string]string{"URI": "tcp://localhost:5432", "User": "zabbix", "Password": "", "Database": "postgres",
+				"TargetSessionAttrs": ""},
+			false,
+		},
+		{
+			"environment",
+			1,
+			0,
+			Session{},
+			[]string{},
+			map[string]string{"URI": "tcp://localhost:5432", "User": "postgres", "Password": "", "Database": "postgres"},
+			map[string]string{"URI": "tcp://localhost:5432", "User": "envuser", "Password": "", "Database": "postgres",
+				"TargetSessionAttrs": ""},
+			false,
+		},
+		{
+			"TLS parameters in URI",
+			0,
+			1,
+			Session{},
+			[]string{"tcp://localhost:5432?sslmode=verify-ca&sslrootcert=/etc/ssl/ca.pem"},
+			map[string]string{"URI": "tcp://localhost:5432?sslmode=verify-ca&sslrootcert=/etc/ssl/ca.pem",
+				"User": "postgres", "Password": "", "Database": "postgres"},
+			map[string]string{"URI": "tcp://localhost:5432", "User": "postgres", "Password": "",
+				"Database": "postgres", "TLSConnect": "verify-ca", "TLSCAFile": "/etc/ssl/ca.pem",
+				"TargetSessionAttrs": ""},
+			false,
+		},
+		{
+			"TLS parameters in URI are not allowed",
+			0,
+			0,
+			Session{},
+			[]string{"tcp://localhost:5432?sslmode=require"},
+			map[string]string{"URI": "tcp://localhost:5432?sslmode=require"},
+			nil,
+			true,
+		},
+		{
+			"unknown service",
+			1,
+			0,
+			Session{Service: "unknown"},
+			[]string{"Prod"},
+			map[string]string{"sessionName": "Prod"},
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Plugin{options: PluginOptions{
+				UseEnvironment:    tt.useEnvironment,
+				AllowKeyTLSParams: tt.allowKeyTLS,
+				Sessions:          map[string]Session{"Prod": tt.session},
+			}}
+
+			err := p.resolveConnParams(tt.rawParams, tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Plugin.resolveConnParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(tt.params, tt.want) {
+				t.Errorf("Plugin.resolveConnParams() = %v, want %v", tt.params, tt.want)
+			}
+		})
+	}
+}
+
+func Test_readConnFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".pgpass")
+
+	var parsed int
+
+	parse := func(path string) (interface{}, error) {
+		parsed++
+
+		return os.ReadFile(path)
+	}
+
+	write := func(content string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := func(want string, wantParsed int) {
+		t.Helper()
+
+		got, err := readConnFile(path, parse)
+		if err != nil {
+			t.Fatalf("readConnFile() error = %v", err)
+		}
+
+		if string(got.([]byte)) != want || parsed != wantParsed {
+			t.Errorf("readConnFile() = %q parsed %d times, want %q parsed %d times", got, parsed, want, wantParsed)
+		}
+	}
+
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	write("*:*:*:*:one", modTime)
+	read("*:*:*:*:one", 1)
+	read("*:*:*:*:one", 1)
+
+	write("*:*:*:*:two", modTime.Add(time.Second))
+	read("*:*:*:*:two", 2)
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readConnFile(path, parse); err == nil {
+		t.Errorf("readConnFile() of a removed file returned no error")
+	}
+}
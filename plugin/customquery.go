@@ -0,0 +1,574 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.zabbix.com/ap/plugin-support/zbxerr"
+)
+
+var (
+	errorQueryRejected     = zbxerr.New("custom query is rejected")
+	errorReadOnlyViolation = zbxerr.New("custom query attempted to modify data")
+	errorStatementTimeout  = zbxerr.New("custom query exceeded its timeout")
+)
+
+// Output modes of custom queries.
+const (
+	// outputRows is a JSON array of row objects keyed by column names.
+	outputRows = "rows"
+	// outputValue is the first column of the first row as a scalar.
+	outputValue = "value"
+	// outputRow is the first row as an object.
+	outputRow = "row"
+	// outputMap is an object of rows keyed by their first column, each row holds the rest of the columns.
+	outputMap = "map"
+	// outputLLD is a JSON array of row objects keyed by LLD macros made of column names.
+	outputLLD = "lld"
+)
+
+// checkOutput returns an error if a given output mode is unknown.
+func checkOutput(mode string) error {
+	switch mode {
+	case outputRows, outputValue, outputRow, outputMap, outputLLD:
+		return nil
+	default:
+		return fmt.Errorf("unknown output mode %q, must be one of %s, %s, %s, %s or %s",
+			mode, outputRows, outputValue, outputRow, outputMap, outputLLD)
+	}
+}
+
+// Types of custom query parameters.
+const (
+	paramTypeString = "string"
+	paramTypeInt    = "int"
+	paramTypeFloat  = "float"
+	paramTypeBool   = "bool"
+)
+
+var reParamName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// queryParam is a named and typed parameter of a custom query. Parameters are bound to the key
+// arguments in the order of their declaration.
+type queryParam struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Default  *string  `json:"default,omitempty"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+	Values   []string `json:"values,omitempty"`
+	Required bool     `json:"required"`
+}
+
+// customQuery is a custom query along with the metadata declared in the comment header of its file:
+//
+//	-- @description: Transactions running longer than a given time
+//	-- @timeout: 5
+//	-- @output: rows
+//	-- @param: age int default=600 min=0
+//	-- @min_version: 12
+//	-- @max_version: 16
+//	-- @extension: pg_stat_statements
+//	-- @readonly: true
+//
+// The header is the leading block of comment lines, directives are lines starting with "-- @".
+// All directives are optional, a file without a header is run as is with all key arguments passed as strings.
+type customQuery struct {
+	name        string
+	sql         string
+	description string
+	timeout     time.Duration
+	output      string
+	params      []queryParam
+	minVersion  int
+	maxVersion  int
+	extension   string
+	// readOnly is nil if the header does not declare it, queryPolicy.apply sets the default.
+	readOnly *bool
+	// searchPath restricts schemas of unqualified names, nil means no restriction.
+	searchPath *string
+	// numericPrecision is the precision numbers of the result are rounded to, it is set by queryPolicy.apply.
+	numericPrecision int
+}
+
+// queryPolicy holds restrictions applied to all custom queries.
+type queryPolicy struct {
+	// readOnly is used by queries which do not declare @readonly.
+	readOnly bool
+	// allow is nil if queries are not restricted to an allow-list.
+	allow *allowList
+	// numericPrecision is the number of digits after the decimal point fractional numbers are rounded to,
+	// a negative value means no rounding.
+	numericPrecision int
+}
+
+// apply checks a query against the allow-list and sets the defaults of the policy.
+func (p queryPolicy) apply(q *customQuery) error {
+	q.numericPrecision = p.numericPrecision
+
+	if q.readOnly == nil {
+		readOnly := p.readOnly
+		q.readOnly = &readOnly
+	}
+
+	if p.allow == nil {
+		return nil
+	}
+
+	tokens, err := scanSQL(q.sql)
+	if err != nil {
+		return err
+	}
+
+	if err = p.allow.check(tokens); err != nil {
+		return err
+	}
+
+	searchPath := p.allow.searchPath()
+	q.searchPath = &searchPath
+
+	return nil
+}
+
+// isReadOnly reports whether a query is executed in a read-only transaction.
+func (q *customQuery) isReadOnly() bool {
+	return q.readOnly != nil && *q.readOnly
+}
+
+// parseCustomQuery parses a custom query file and its header. It returns an error if the query is empty,
+// consists of several statements or the header is invalid.
+func parseCustomQuery(name, text string) (*customQuery, error) {
+	q := &customQuery{
+		name:   name,
+		sql:    strings.TrimRight(strings.TrimSpace(text), ";"),
+		output: outputRows,
+	}
+
+	seen := make(map[string]bool)
+	lines := strings.Split(text, "\n")
+	body := ""
+
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "--") {
+			body = strings.Join(lines[i:], "\n")
+
+			break
+		}
+
+		line = strings.TrimSpace(strings.TrimPrefix(line, "--"))
+		if !strings.HasPrefix(line, "@") {
+			continue
+		}
+
+		directive, value, ok := strings.Cut(line[1:], ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header line %q: must be \"-- @directive: value\"", line)
+		}
+
+		directive = strings.TrimSpace(directive)
+		value = strings.TrimSpace(value)
+
+		if seen[directive] && directive != "param" {
+			return nil, fmt.Errorf("duplicate @%s", directive)
+		}
+
+		seen[directive] = true
+
+		if err := q.setDirective(directive, value); err != nil {
+			return nil, fmt.Errorf("invalid @%s: %w", directive, err)
+		}
+	}
+
+	if strings.TrimSpace(strings.TrimRight(strings.TrimSpace(body), ";")) == "" {
+		return nil, errors.New("query is empty")
+	}
+
+	tokens, err := scanSQL(q.sql)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkSingleStatement(tokens); err != nil {
+		return nil, err
+	}
+
+	if q.minVersion != 0 && q.maxVersion != 0 && q.minVersion > q.maxVersion {
+		return nil, errors.New("@min_version is greater than @max_version")
+	}
+
+	return q, nil
+}
+
+func (q *customQuery) setDirective(directive, value string) error {
+	var err error
+
+	switch directive {
+	case "description":
+		q.description = value
+	case "timeout":
+		var sec int
+		if sec, err = strconv.Atoi(value); err == nil && sec <= 0 {
+			err = errors.New("must be a positive number of seconds")
+		}
+
+		q.timeout = time.Duration(sec) * time.Second
+	case "output":
+		err = checkOutput(value)
+		q.output = value
+	case "param":
+		var p queryParam
+		if p, err = parseQueryParam(value); err == nil {
+			for _, prev := range q.params {
+				if prev.Name == p.Name {
+					return fmt.Errorf("parameter %s is declared twice", p.Name)
+				}
+			}
+
+			q.params = append(q.params, p)
+		}
+	case "min_version":
+		q.minVersion, err = parseMajorVersion(value)
+	case "max_version":
+		if q.maxVersion, err = parseMajorVersion(value); err == nil {
+			q.maxVersion += 9999
+		}
+	case "extension":
+		if !reParamName.MatchString(value) {
+			err = fmt.Errorf("invalid extension name %q", value)
+		}
+
+		q.extension = value
+	case "readonly":
+		var readOnly bool
+		if readOnly, err = strconv.ParseBool(value); err == nil {
+			q.readOnly = &readOnly
+		}
+	default:
+		err = errors.New("unknown directive")
+	}
+
+	return err
+}
+
+// parseMajorVersion converts a major version, e.g. 13, to a server_version_num value.
+func parseMajorVersion(value string) (int, error) {
+	major, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if major*10000 < MinSupportedPGVersion {
+		return 0, fmt.Errorf("PostgreSQL %d is not supported", major)
+	}
+
+	return major * 10000, nil
+}
+
+// parseQueryParam parses a parameter declaration: "name type [default=v] [min=n] [max=n] [values=a|b]".
+func parseQueryParam(decl string) (queryParam, error) {
+	fields := strings.Fields(decl)
+	if len(fields) < 2 {
+		return queryParam{}, fmt.Errorf("%q must be \"name type [options]\"", decl)
+	}
+
+	p := queryParam{Name: fields[0], Type: fields[1], Required: true}
+
+	if !reParamName.MatchString(p.Name) {
+		return p, fmt.Errorf("invalid parameter name %q", p.Name)
+	}
+
+	switch p.Type {
+	case paramTypeString, paramTypeInt, paramTypeFloat, paramTypeBool:
+	default:
+		return p, fmt.Errorf("unknown type %q of parameter %s", p.Type, p.Name)
+	}
+
+	for _, opt := range fields[2:] {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return p, fmt.Errorf("invalid option %q of parameter %s", opt, p.Name)
+		}
+
+		switch key {
+		case "default":
+			v := value
+			p.Default = &v
+			p.Required = false
+		case "min", "max":
+			if p.Type != paramTypeInt && p.Type != paramTypeFloat {
+				return p, fmt.Errorf("%s is allowed only for numeric parameters, %s is %s", key, p.Name, p.Type)
+			}
+
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return p, fmt.Errorf("invalid %s of parameter %s: %w", key, p.Name, err)
+			}
+
+			if key == "min" {
+				p.Min = &n
+			} else {
+				p.Max = &n
+			}
+		case "values":
+			p.Values = strings.Split(value, "|")
+		default:
+			return p, fmt.Errorf("unknown option %q of parameter %s", key, p.Name)
+		}
+	}
+
+	if p.Default != nil {
+		if _, err := p.bind(*p.Default); err != nil {
+			return p, fmt.Errorf("invalid default: %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+// bind validates a value of the parameter and returns it in the canonical form of its type.
+func (p queryParam) bind(value string) (string, error) {
+	var n float64
+
+	switch p.Type {
+	case paramTypeInt:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("parameter %s must be an integer, got %q", p.Name, value)
+		}
+
+		value = strconv.FormatInt(i, 10)
+		n = float64(i)
+	case paramTypeFloat:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", fmt.Errorf("parameter %s must be a number, got %q", p.Name, value)
+		}
+
+		value = strconv.FormatFloat(f, 'g', -1, 64)
+		n = f
+	case paramTypeBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("parameter %s must be a boolean, got %q", p.Name, value)
+		}
+
+		value = strconv.FormatBool(b)
+	}
+
+	if p.Min != nil && n < *p.Min {
+		return "", fmt.Errorf("parameter %s must be at least %g, got %s", p.Name, *p.Min, value)
+	}
+
+	if p.Max != nil && n > *p.Max {
+		return "", fmt.Errorf("parameter %s must be at most %g, got %s", p.Name, *p.Max, value)
+	}
+
+	if len(p.Values) > 0 {
+		for _, v := range p.Values {
+			if v == value {
+				return value, nil
+			}
+		}
+
+		return "", fmt.Errorf("parameter %s must be one of %s, got %q", p.Name, strings.Join(p.Values, ", "), value)
+	}
+
+	return value, nil
+}
+
+// bindArgs returns query arguments for given key arguments. Queries without declared parameters receive
+// the key arguments as is, otherwise each argument is validated and empty or missing ones are replaced
+// by defaults.
+func (q *customQuery) bindArgs(args []string) ([]interface{}, error) {
+	if len(q.params) == 0 {
+		res := make([]interface{}, len(args))
+		for i, v := range args {
+			res[i] = v
+		}
+
+		return res, nil
+	}
+
+	if len(args) > len(q.params) {
+		return nil, fmt.Errorf("query %s takes %d arguments, got %d", q.name, len(q.params), len(args))
+	}
+
+	res := make([]interface{}, len(q.params))
+
+	for i, p := range q.params {
+		value := ""
+		if i < len(args) {
+			value = args[i]
+		}
+
+		if value == "" {
+			if p.Default == nil {
+				return nil, fmt.Errorf("parameter %s of query %s is required", p.Name, q.name)
+			}
+
+			value = *p.Default
+		}
+
+		v, err := p.bind(value)
+		if err != nil {
+			return nil, err
+		}
+
+		res[i] = v
+	}
+
+	return res, nil
+}
+
+var reLLDMacroChars = regexp.MustCompile(`[^A-Z0-9_.]`)
+
+// formatOutput converts query results to a given output mode. Results of the value mode are returned
+// as plain text, everything else as JSON. The value and row modes require at least one row.
+func formatOutput(mode string, columns []string, table [][]interface{}) (string, error) {
+	var res interface{}
+
+	switch mode {
+	case outputValue:
+		if len(table) == 0 || len(columns) == 0 || table[0][0] == nil {
+			return "", zbxerr.ErrorEmptyResult.Wrap(errors.New("query returned no value"))
+		}
+
+		return scalarString(table[0][0])
+	case outputRow:
+		if len(table) == 0 {
+			return "", zbxerr.ErrorEmptyResult.Wrap(errors.New("query returned no rows"))
+		}
+
+		res = rowObject(columns, table[0])
+	case outputMap:
+		if len(columns) == 0 {
+			return "", zbxerr.ErrorCannotParseResult.Wrap(errors.New("query returned no columns"))
+		}
+
+		m := make(map[string]interface{}, len(table))
+
+		for _, values := range table {
+			key := "null"
+			if values[0] != nil {
+				s, err := scalarString(values[0])
+				if err != nil {
+					return "", err
+				}
+
+				key = s
+			}
+
+			if _, ok := m[key]; ok {
+				return "", zbxerr.ErrorCannotParseResult.Wrap(fmt.Errorf("duplicate value %q of column %s, "+
+					"the first column must be unique in the map output mode", key, columns[0]))
+			}
+
+			m[key] = rowObject(columns[1:], values[1:])
+		}
+
+		res = m
+	case outputLLD:
+		macros := make([]string, len(columns))
+		for i, column := range columns {
+			macros[i] = lldMacro(column)
+		}
+
+		rows := make([]map[string]interface{}, 0, len(table))
+
+		for _, values := range table {
+			row := make(map[string]interface{}, len(values))
+
+			for i, v := range values {
+				s := ""
+				if v != nil {
+					var err error
+					if s, err = scalarString(v); err != nil {
+						return "", err
+					}
+				}
+
+				row[macros[i]] = s
+			}
+
+			rows = append(rows, row)
+		}
+
+		res = rows
+	default:
+		res = rowObjects(columns, table)
+	}
+
+	jsonRes, err := json.Marshal(res)
+	if err != nil {
+		return "", zbxerr.ErrorCannotMarshalJSON.Wrap(err)
+	}
+
+	return string(jsonRes), nil
+}
+
+// rowObjects converts rows of values to maps of column names to the values.
+func rowObjects(columns []string, table [][]interface{}) []map[string]interface{} {
+	res := make([]map[string]interface{}, 0, len(table))
+
+	for _, values := range table {
+		res = append(res, rowObject(columns, values))
+	}
+
+	return res
+}
+
+func rowObject(columns []string, values []interface{}) map[string]interface{} {
+	row := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		row[column] = values[i]
+	}
+
+	return row
+}
+
+// scalarString returns a string as is and any other JSON value as JSON.
+func scalarString(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", zbxerr.ErrorCannotMarshalJSON.Wrap(err)
+	}
+
+	return string(b), nil
+}
+
+// lldMacro converts a column name to an LLD macro, e.g. "db_name" to "{#DB_NAME}". Characters which are not
+// allowed in macro names are replaced by underscores.
+func lldMacro(column string) string {
+	return "{#" + reLLDMacroChars.ReplaceAllString(strings.ToUpper(column), "_") + "}"
+}
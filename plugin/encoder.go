@@ -0,0 +1,300 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgtype"
+)
+
+// secondsPerDay, daysPerMonth and daysPerYear are used to convert intervals to seconds the same way
+// as extract(epoch FROM interval) does.
+const (
+	secondsPerDay = 86400
+	daysPerMonth  = 30
+	daysPerYear   = 365.25
+)
+
+// resultEncoder converts query results to JSON values according to PostgreSQL types of their columns:
+// integers, numeric and floats become numbers, bool becomes boolean, timestamps become Unix timestamps,
+// intervals become seconds, json and jsonb are embedded as is, arrays become JSON arrays and NULL becomes null.
+// Values of other types are kept as strings.
+type resultEncoder struct {
+	// numericPrecision is the number of digits after the decimal point fractional numbers are rounded to.
+	// A negative value means no rounding.
+	numericPrecision int
+}
+
+// encodeTable returns column names of given rows and JSON values of each row in the order of the columns.
+func (e resultEncoder) encodeTable(rows *sql.Rows) ([]string, [][]interface{}, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	columns := make([]string, len(columnTypes))
+	for i, column := range columnTypes {
+		columns[i] = column.Name()
+	}
+
+	values := make([]interface{}, len(columnTypes))
+	valuePointers := make([]interface{}, len(values))
+
+	for i := range values {
+		valuePointers[i] = &values[i]
+	}
+
+	res := make([][]interface{}, 0)
+
+	for rows.Next() {
+		if err = rows.Scan(valuePointers...); err != nil {
+			return nil, nil, err
+		}
+
+		row := make([]interface{}, len(columnTypes))
+		for i, column := range columnTypes {
+			row[i] = e.value(column.DatabaseTypeName(), values[i])
+		}
+
+		res = append(res, row)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return columns, res, nil
+}
+
+// value converts a value of a column of a given type to a JSON value.
+func (e resultEncoder) value(typeName string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return e.text(typeName, string(v))
+	case string:
+		return e.text(typeName, v)
+	case time.Time:
+		return json.Number(formatRat(big.NewRat(v.UnixMicro(), int64(time.Second/time.Microsecond)), 6))
+	case float64:
+		return e.float(v)
+	default:
+		return v
+	}
+}
+
+// text converts a value of a given type in the PostgreSQL text format to a JSON value.
+func (e resultEncoder) text(typeName, s string) interface{} {
+	if strings.HasPrefix(typeName, "_") {
+		return e.array(strings.TrimPrefix(typeName, "_"), s)
+	}
+
+	switch typeName {
+	case "INT2", "INT4", "INT8", "OID", "XID", "CID":
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+	case "NUMERIC":
+		return e.numeric(s)
+	case "FLOAT4", "FLOAT8":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return e.float(f)
+		}
+	case "BOOL":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	case "JSON", "JSONB":
+		if json.Valid([]byte(s)) {
+			return json.RawMessage(s)
+		}
+	case "INTERVAL":
+		var interval pgtype.Interval
+		if err := interval.DecodeText(nil, []byte(s)); err == nil {
+			return intervalSeconds(interval)
+		}
+	}
+
+	return s
+}
+
+// array converts an array with elements of a given type in the PostgreSQL text format to a JSON array,
+// multidimensional arrays become nested JSON arrays.
+func (e resultEncoder) array(elemType, s string) interface{} {
+	arr, err := pgtype.ParseUntypedTextArray(s)
+	if err != nil {
+		return s
+	}
+
+	elems := make([]interface{}, len(arr.Elements))
+
+	for i, elem := range arr.Elements {
+		if elem == "NULL" && !arr.Quoted[i] {
+			continue
+		}
+
+		elems[i] = e.text(elemType, elem)
+	}
+
+	if len(arr.Dimensions) <= 1 {
+		return elems
+	}
+
+	return nestArray(elems, arr.Dimensions)
+}
+
+// nestArray splits flat elements of a multidimensional array into nested arrays of given dimensions.
+func nestArray(elems []interface{}, dims []pgtype.ArrayDimension) []interface{} {
+	if len(dims) == 1 {
+		return elems
+	}
+
+	size := len(elems) / int(dims[0].Length)
+	res := make([]interface{}, 0, dims[0].Length)
+
+	for i := 0; i+size <= len(elems) && size > 0; i += size {
+		res = append(res, nestArray(elems[i:i+size], dims[1:]))
+	}
+
+	return res
+}
+
+// numeric converts a numeric value to a JSON number rounded to the precision.
+// NaN and infinities, which JSON does not support, are kept as strings.
+func (e resultEncoder) numeric(s string) interface{} {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return s
+	}
+
+	if e.numericPrecision < 0 {
+		return json.Number(s)
+	}
+
+	return json.Number(formatRat(r, e.numericPrecision))
+}
+
+// float converts a float value to a JSON number rounded to the precision.
+// NaN and infinities, which JSON does not support, are converted to strings.
+func (e resultEncoder) float(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+
+	if e.numericPrecision < 0 {
+		return f
+	}
+
+	return json.Number(formatRat(new(big.Rat).SetFloat64(f), e.numericPrecision))
+}
+
+// formatRat formats a number with a given number of digits after the decimal point omitting trailing zeros.
+func formatRat(r *big.Rat, precision int) string {
+	s := r.FloatString(precision)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+
+	if s == "-0" {
+		s = "0"
+	}
+
+	return s
+}
+
+// intervalSeconds returns the number of seconds of an interval the same way as extract(epoch FROM interval) does.
+func intervalSeconds(i pgtype.Interval) float64 {
+	years, months := i.Months/12, i.Months%12
+
+	return float64(years)*daysPerYear*secondsPerDay + float64(months)*daysPerMonth*secondsPerDay +
+		float64(i.Days)*secondsPerDay + float64(i.Microseconds)/float64(time.Second/time.Microsecond)
+}
+
+// normalizeJSON rounds fractional numbers of a JSON document to the precision. Documents built by PostgreSQL
+// already have typed values, so only the precision of numbers needs to be adjusted. Number literals are
+// rewritten in place, so the order of keys and the formatting of the document are kept.
+// Values which are not JSON objects or arrays are returned unchanged.
+func (e resultEncoder) normalizeJSON(s string) string {
+	trimmed := strings.TrimSpace(s)
+	if e.numericPrecision < 0 || (!strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[")) ||
+		!json.Valid([]byte(trimmed)) {
+		return s
+	}
+
+	var buf strings.Builder
+
+	buf.Grow(len(s))
+
+	for i := 0; i < len(s); {
+		end := i + 1
+
+		switch c := s[i]; {
+		case c == '"':
+			end = stringEnd(s, i)
+			buf.WriteString(s[i:end])
+		case c == '-' || c >= '0' && c <= '9':
+			for end < len(s) && strings.IndexByte("0123456789+-.eE", s[end]) >= 0 {
+				end++
+			}
+
+			buf.WriteString(e.roundNumber(s[i:end]))
+		default:
+			buf.WriteByte(c)
+		}
+
+		i = end
+	}
+
+	return buf.String()
+}
+
+// roundNumber rounds a JSON number literal to the precision, integers are returned unchanged.
+func (e resultEncoder) roundNumber(s string) string {
+	if !strings.ContainsAny(s, ".eE") {
+		return s
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return s
+	}
+
+	return formatRat(r, e.numericPrecision)
+}
+
+// stringEnd returns the index following the closing quote of a JSON string starting at a given index.
+func stringEnd(s string, start int) int {
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+
+	return len(s)
+}
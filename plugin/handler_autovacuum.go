@@ -25,18 +25,20 @@ import (
 	"github.com/jackc/pgx/v4"
 )
 
+const keyAutovacuum = "pgsql.autovacuum.count"
+
+var _ = registerMetric(keyAutovacuum, metricSpec{
+	description: "Returns count of autovacuum workers.",
+	params:      commonParams,
+	handler:     autovacuumHandler,
+})
+
 // autovacuumHandler returns count of autovacuum workers if all is OK or nil otherwise.
 func autovacuumHandler(ctx context.Context, conn PostgresClient,
 	_ string, _ map[string]string, _ ...string) (interface{}, error) {
 	var countAutovacuumWorkers int64
 
-	query := `SELECT count(*)
-				FROM pg_catalog.pg_stat_activity
-			   WHERE query like '%%autovacuum%%'
-				 AND state <> 'idle'
-				 AND pid <> pg_catalog.pg_backend_pid()`
-
-	row, err := conn.QueryRow(ctx, query)
+	row, err := conn.QueryRowBuiltin(ctx, keyAutovacuum)
 	if err != nil {
 		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
 	}
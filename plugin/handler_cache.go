@@ -25,14 +25,20 @@ import (
 	"github.com/jackc/pgx/v4"
 )
 
+const keyCache = "pgsql.cache.hit"
+
+var _ = registerMetric(keyCache, metricSpec{
+	description: "Returns cache hit percent.",
+	params:      commonParams,
+	handler:     cacheHandler,
+})
+
 // cacheHandler finds cache hit percent and returns int64 if all is OK or nil otherwise.
 func cacheHandler(ctx context.Context, conn PostgresClient,
 	_ string, _ map[string]string, _ ...string) (interface{}, error) {
 	var cache float64
 
-	query := `SELECT round(sum(blks_hit)*100/sum(blks_hit+blks_read), 2) FROM pg_catalog.pg_stat_database;`
-
-	row, err := conn.QueryRow(ctx, query)
+	row, err := conn.QueryRowBuiltin(ctx, keyCache)
 	if err != nil {
 		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
 	}
@@ -19,82 +19,207 @@ package plugin
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
+	"git.zabbix.com/ap/plugin-support/metric"
 	"git.zabbix.com/ap/plugin-support/zbxerr"
-	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgconn"
 )
 
-// customQueryHandler executes custom user queries from *.sql files.
+const (
+	keyCustomQuery       = "pgsql.custom.query"
+	keyCustomQueryOutput = "pgsql.custom.query.output"
+)
+
+var paramQueryName = metric.NewParam("QueryName", "Name of a custom query "+
+	"(must be equal to a name of an SQL file without an extension).").SetRequired()
+
+var (
+	_ = registerMetric(keyCustomQuery, metricSpec{
+		description: "Returns result of a custom query.",
+		params:      paramsWith(paramQueryName),
+		varParam:    true,
+		handler:     customQueryHandler,
+	})
+	_ = registerMetric(keyCustomQueryOutput, metricSpec{
+		description: "Returns result of a custom query in a given output mode.",
+		params: paramsWith(paramQueryName, metric.NewParam("Output", "Output mode: rows, value, row, map or lld. "+
+			"The mode declared by the query is used if empty.")),
+		varParam: true,
+		handler:  customQueryHandler,
+	})
+)
+
+// customQueryHandler executes custom user queries from *.sql files, enforcing the metadata declared
+// in their headers.
 func customQueryHandler(ctx context.Context, conn PostgresClient,
-	_ string, params map[string]string, extraParams ...string) (interface{}, error) {
+	key string, params map[string]string, extraParams ...string) (interface{}, error) {
 	queryName := params["QueryName"]
 
-	queryArgs := make([]interface{}, len(extraParams))
-	for i, v := range extraParams {
-		queryArgs[i] = v
+	q, err := conn.CustomQuery(queryName)
+	if err != nil {
+		return nil, err
+	}
+
+	output := q.output
+	if key == keyCustomQueryOutput && params["Output"] != "" {
+		output = params["Output"]
+
+		if err = checkOutput(output); err != nil {
+			return nil, zbxerr.ErrorInvalidParams.Wrap(err)
+		}
 	}
 
-	rows, err := conn.QueryByName(ctx, queryName, queryArgs...)
+	res, err := runCustomQuery(ctx, conn, q, output, extraParams)
 	if err != nil {
-		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+		return nil, err
 	}
-	defer rows.Close()
 
-	// JSON marshaling
-	var data []string
+	return res, nil
+}
 
-	columns, err := rows.Columns()
+// runCustomQuery executes a custom query with given key arguments enforcing the metadata declared in its header
+// and returns its result in a given output mode.
+func runCustomQuery(ctx context.Context, conn PostgresClient, q *customQuery, output string,
+	args []string) (string, error) {
+	err := checkVersionRange("query "+q.name, q.minVersion, q.maxVersion, conn.PostgresVersion())
 	if err != nil {
-		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+		return "", err
+	}
+
+	queryArgs, err := q.bindArgs(args)
+	if err != nil {
+		return "", zbxerr.ErrorInvalidParams.Wrap(err)
+	}
+
+	// callCtx is done when CallTimeout expires, it tells whether CallTimeout or the query's timeout fired.
+	callCtx := ctx
+
+	var callTimeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		callTimeout = time.Until(deadline)
+	}
+
+	// The timeout is also set as statement_timeout, so the server cancels the query even if the plugin
+	// could not.
+	timeout := q.timeout
+	if timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	} else {
+		timeout = callTimeout
+	}
+
+	if q.extension != "" {
+		if err = checkExtension(ctx, conn, q); err != nil {
+			return "", err
+		}
+	}
+
+	var (
+		columns []string
+		table   [][]interface{}
+	)
+
+	// Numbers are rounded by their column types, so a scalar of the value output mode is rounded as well,
+	// unlike a text which only looks like a number.
+	encode := func(rows *sql.Rows) (err error) {
+		columns, table, err = resultEncoder{numericPrecision: q.numericPrecision}.encodeTable(rows)
+
+		return err
+	}
+
+	// The settings are applied in read-write transactions as well, so the allow-list restricts unqualified
+	// names of all queries.
+	settings := make(map[string]string)
+
+	if timeout > 0 {
+		settings["statement_timeout"] = strconv.FormatInt(timeout.Milliseconds(), 10)
 	}
 
-	values := make([]interface{}, len(columns))
-	valuePointers := make([]interface{}, len(values))
+	if q.searchPath != nil {
+		settings["search_path"] = *q.searchPath
+	}
 
-	for i := range values {
-		valuePointers[i] = &values[i]
+	err = conn.QueryTx(ctx, q.isReadOnly(), settings, encode, q.sql, queryArgs...)
+	if err != nil {
+		return "", customQueryError(callCtx, q, callTimeout, err)
 	}
 
-	results := make(map[string]interface{})
+	return formatOutput(output, columns, table)
+}
 
-	for rows.Next() {
-		err = rows.Scan(valuePointers...)
-		if err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
-				return nil, zbxerr.ErrorEmptyResult.Wrap(err)
+// customQueryError turns errors caused by restrictions of a custom query into distinct errors. The errors
+// of PostgreSQL are not wrapped, so they are not classified again as errors of the server. A timeout error
+// names the timeout which expired: the query's own one or CallTimeout, whose context is callCtx.
+func customQueryError(callCtx context.Context, q *customQuery, callTimeout time.Duration, err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == sqlStateReadOnly && q.isReadOnly():
+			return errorReadOnlyViolation.Wrap(fmt.Errorf("query %s: %s; custom queries run in a read-only "+
+				"transaction, declare \"-- @readonly: false\" in the query header to allow modifications",
+				q.name, pgErr.Message))
+		case pgErr.Code == sqlStateQueryCanceled && strings.Contains(pgErr.Message, "statement timeout"):
+			// statement_timeout is the query's timeout if it has one, otherwise the time left until CallTimeout.
+			if q.timeout > 0 {
+				return errorStatementTimeout.Wrap(fmt.Errorf("query %s did not finish within its timeout of %s",
+					q.name, q.timeout))
 			}
 
-			return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+			return zbxerr.ErrorCannotFetchData.Wrap(fmt.Errorf("query %s did not finish within CallTimeout "+
+				"of %s", q.name, callTimeout.Round(time.Millisecond)))
 		}
+	}
 
-		setResult(results, values, columns)
+	if errors.Is(err, context.DeadlineExceeded) {
+		if callCtx.Err() != nil {
+			return zbxerr.ErrorCannotFetchData.Wrap(fmt.Errorf("query %s did not finish within CallTimeout "+
+				"of %s: %w", q.name, callTimeout.Round(time.Millisecond), err))
+		}
 
-		jsonRes, err := json.Marshal(results)
-		if err != nil {
-			return nil, err
+		if q.timeout > 0 {
+			return errorStatementTimeout.Wrap(fmt.Errorf("query %s did not finish within its timeout of %s",
+				q.name, q.timeout))
 		}
+	}
+
+	return zbxerr.ErrorCannotFetchData.Wrap(err)
+}
 
-		data = append(data, strings.TrimSpace(string(jsonRes)))
+// checkExtension returns an error if the extension required by a query is not installed in the database.
+func checkExtension(ctx context.Context, conn PostgresClient, q *customQuery) error {
+	installed, err := extensionInstalled(ctx, conn, q.extension)
+	if err != nil {
+		return zbxerr.ErrorCannotFetchData.Wrap(err)
 	}
 
-	// Any errors encountered by rows.Next or rows.Scan will be returned here
-	if rows.Err() != nil {
-		return nil, err
+	if !installed {
+		return zbxerr.ErrorUnsupportedMetric.Wrap(fmt.Errorf("query %s requires the %s extension, "+
+			"which is not installed in the database", q.name, q.extension))
 	}
 
-	return "[" + strings.Join(data, ",") + "]", nil
+	return nil
 }
 
-func setResult(results map[string]interface{}, values []interface{}, columns []string) {
-	for i, value := range values {
-		switch v := value.(type) {
-		case []uint8:
-			results[columns[i]] = string(v)
-		default:
-			results[columns[i]] = value
-		}
+// extensionInstalled reports whether an extension is installed in the database of a connection.
+func extensionInstalled(ctx context.Context, conn PostgresClient, name string) (bool, error) {
+	row, err := conn.QueryRowBuiltin(ctx, "pgsql.extension.installed", name)
+	if err != nil {
+		return false, err
 	}
+
+	var installed bool
+	if err = row.Scan(&installed); err != nil {
+		return false, err
+	}
+
+	return installed, nil
 }
@@ -0,0 +1,226 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"git.zabbix.com/ap/plugin-support/metric"
+	"git.zabbix.com/ap/plugin-support/zbxerr"
+)
+
+const keyCustomQueryDatabases = "pgsql.custom.query.databases"
+
+// builtinQueryPrefix is the prefix of names of built-in queries.
+const builtinQueryPrefix = "pgsql."
+
+var _ = registerMetric(keyCustomQueryDatabases, metricSpec{
+	description: "Returns JSON with results of a custom or built-in query in each connectable database.",
+	params: paramsWith(
+		metric.NewParam("QueryName", "Name of a custom query or a built-in query.").SetRequired(),
+		metric.NewParam("Include", "Regular expression database names must match, all databases if empty."),
+		metric.NewParam("Exclude", "Regular expression database names must not match, none if empty."),
+	),
+	varParam: true,
+	handler:  customQueryDatabasesHandler,
+})
+
+// customQueryDatabasesHandler executes a query in each connectable database matching the filters and returns
+// JSON with the results keyed by database names. A custom query is used if there is one with the name,
+// otherwise a key of the plugin, which is evaluated with the remaining parameters as its own ones.
+// Databases which lack the extension required by a custom query are skipped. Failures in a database are
+// reported as {"error": message} in place of its result, so that the other databases are still monitored.
+func customQueryDatabasesHandler(ctx context.Context, conn PostgresClient,
+	_ string, params map[string]string, extraParams ...string) (interface{}, error) {
+	include, err := compileDatabaseFilter(params["Include"])
+	if err != nil {
+		return nil, zbxerr.ErrorInvalidParams.Wrap(fmt.Errorf("invalid Include: %w", err))
+	}
+
+	exclude, err := compileDatabaseFilter(params["Exclude"])
+	if err != nil {
+		return nil, zbxerr.ErrorInvalidParams.Wrap(fmt.Errorf("invalid Exclude: %w", err))
+	}
+
+	queryName := params["QueryName"]
+
+	var builtin *registeredMetric
+
+	q, err := conn.CustomQuery(queryName)
+	if err != nil {
+		if !strings.HasPrefix(queryName, builtinQueryPrefix) {
+			return nil, err
+		}
+
+		if builtin, err = databaseKey(queryName); err != nil {
+			return nil, err
+		}
+	}
+
+	databases, err := connectableDatabases(ctx, conn)
+	if err != nil {
+		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+	}
+
+	results := make(map[string]json.RawMessage)
+
+	for _, dbname := range filterDatabases(databases, include, exclude) {
+		res, skip, err := runInDatabase(ctx, conn, dbname, q, builtin, queryName, extraParams)
+
+		// Results and errors of each database are brought to the same form as if the key was requested directly.
+		res, err = keyResult(res, err, params["User"], conn.ResultEncoder())
+		if err != nil {
+			Impl.Debugf("[%s] Cannot get %s in database %q: %s", Name, queryName, dbname, err.Error())
+
+			results[dbname] = jsonError(err)
+
+			continue
+		}
+
+		if skip {
+			continue
+		}
+
+		if results[dbname], err = jsonValue(res); err != nil {
+			return nil, err
+		}
+	}
+
+	jsonRes, err := json.Marshal(results)
+	if err != nil {
+		return nil, zbxerr.ErrorCannotMarshalJSON.Wrap(err)
+	}
+
+	return string(jsonRes), nil
+}
+
+// runInDatabase executes a custom query or a key in a given database. skip is set if the database lacks
+// the extension required by the custom query.
+func runInDatabase(ctx context.Context, conn PostgresClient, dbname string, q *customQuery,
+	builtin *registeredMetric, key string, args []string) (res interface{}, skip bool, err error) {
+	dbConn, err := conn.WithDatabase(dbname)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if q == nil {
+		res, err = runKey(ctx, dbConn, builtin, key, dbname, args)
+
+		return res, false, err
+	}
+
+	if q.extension != "" {
+		installed, err := extensionInstalled(ctx, dbConn, q.extension)
+		if err != nil {
+			return nil, false, zbxerr.ErrorCannotFetchData.Wrap(err)
+		}
+
+		if !installed {
+			return nil, true, nil
+		}
+	}
+
+	res, err = runCustomQuery(ctx, dbConn, q, q.output, args)
+
+	return res, false, err
+}
+
+// databaseKey returns a registered key which can be executed in each database.
+func databaseKey(key string) (*registeredMetric, error) {
+	m, ok := registry[key]
+	if !ok || m.handler == nil || key == keyCustomQueryDatabases {
+		return nil, zbxerr.ErrorUnsupportedMetric.Wrap(
+			fmt.Errorf("%s is neither a custom query nor a key which can be executed in each database", key))
+	}
+
+	return m, nil
+}
+
+// runKey executes a key in a database the connection is made to. The arguments are passed as parameters
+// of the key following the connection ones, the Database parameter is set to the database.
+func runKey(ctx context.Context, conn PostgresClient, m *registeredMetric, key, dbname string,
+	args []string) (interface{}, error) {
+	if len(args) > len(m.params)-len(commonParams) && !m.varParam {
+		return nil, zbxerr.ErrorTooManyParameters
+	}
+
+	rawParams := append(make([]string, len(leadingParams)), args...)
+
+	params, extraParams, err := m.metric.EvalParams(rawParams, map[string]Session{})
+	if err != nil {
+		return nil, zbxerr.ErrorInvalidParams.Wrap(err)
+	}
+
+	params["Database"] = dbname
+
+	if err = m.checkVersion(key, conn.PostgresVersion()); err != nil {
+		return nil, err
+	}
+
+	return m.handler(ctx, conn, key, params, extraParams...)
+}
+
+// jsonError returns an object with the message of an error, which replaces the result of a database.
+func jsonError(err error) json.RawMessage {
+	res, _ := json.Marshal(map[string]string{"error": err.Error()})
+
+	return res
+}
+
+// jsonValue embeds a string result which is JSON as is, any other string as a JSON string and results
+// of other types as their JSON values.
+func jsonValue(res interface{}) (json.RawMessage, error) {
+	if s, ok := res.(string); ok && json.Valid([]byte(s)) {
+		return json.RawMessage(s), nil
+	}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		return nil, zbxerr.ErrorCannotMarshalJSON.Wrap(err)
+	}
+
+	return b, nil
+}
+
+// compileDatabaseFilter compiles a regular expression of database names, an empty one means no filter.
+func compileDatabaseFilter(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	return regexp.Compile(expr)
+}
+
+// filterDatabases returns databases matching include and not matching exclude, nil filters are ignored.
+func filterDatabases(databases []string, include, exclude *regexp.Regexp) []string {
+	res := make([]string, 0, len(databases))
+
+	for _, dbname := range databases {
+		if include != nil && !include.MatchString(dbname) || exclude != nil && exclude.MatchString(dbname) {
+			continue
+		}
+
+		res = append(res, dbname)
+	}
+
+	return res
+}
@@ -25,17 +25,20 @@ import (
 	"github.com/jackc/pgx/v4"
 )
 
+const keyDatabaseSize = "pgsql.db.size"
+
+var _ = registerMetric(keyDatabaseSize, metricSpec{
+	description: "Returns size in bytes for specific database.",
+	params:      commonParams,
+	handler:     databaseSizeHandler,
+})
+
 // databaseSizeHandler gets info about count and size of archive files and returns JSON if all is OK or nil otherwise.
 func databaseSizeHandler(ctx context.Context, conn PostgresClient,
 	_ string, params map[string]string, _ ...string) (interface{}, error) {
 	var countSize int64
 
-	query := `SELECT pg_database_size(datname::text)
-		FROM pg_catalog.pg_database
-   		WHERE datistemplate = false
-			 AND datname = $1;`
-
-	row, err := conn.QueryRow(ctx, query, params["Database"])
+	row, err := conn.QueryRowBuiltin(ctx, keyDatabaseSize, params["Database"])
 	if err != nil {
 		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
 	}
@@ -0,0 +1,66 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+
+	"git.zabbix.com/ap/plugin-support/zbxerr"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	keyPartitions          = "pgsql.partitions"
+	keyPartitionsDiscovery = "pgsql.partitions.discovery"
+)
+
+var _ = registerMetric(keyPartitions, metricSpec{
+	description: "Returns JSON with partitions info for each partitioned table.",
+	params:      commonParams,
+	handler:     partitionsHandler,
+})
+
+var _ = registerMetric(keyPartitionsDiscovery, metricSpec{
+	description: "Returns JSON discovery rule with names of partitioned tables.",
+	params:      commonParams,
+	handler:     partitionsHandler,
+})
+
+// partitionsHandler gets info about partitioned tables of the current database and their partitions
+// and returns JSON if all is OK or nil otherwise.
+func partitionsHandler(ctx context.Context, conn PostgresClient,
+	key string, _ map[string]string, _ ...string) (interface{}, error) {
+	var partitionsJSON string
+
+	row, err := conn.QueryRowBuiltin(ctx, key)
+	if err != nil {
+		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+	}
+
+	err = row.Scan(&partitionsJSON)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, zbxerr.ErrorEmptyResult.Wrap(err)
+		}
+
+		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+	}
+
+	return partitionsJSON, nil
+}
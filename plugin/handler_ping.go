@@ -19,9 +19,16 @@ package plugin
 
 import (
 	"context"
-	"fmt"
 )
 
+const keyPing = "pgsql.ping"
+
+var _ = registerMetric(keyPing, metricSpec{
+	description: "Tests if connection is alive or not.",
+	params:      commonParams,
+	handler:     pingHandler,
+})
+
 const (
 	pingFailed = 0
 	pingOk     = 1
@@ -32,7 +39,7 @@ func pingHandler(ctx context.Context, conn PostgresClient,
 	_ string, _ map[string]string, _ ...string) (interface{}, error) {
 	var res int
 
-	row, err := conn.QueryRow(ctx, fmt.Sprintf("SELECT %d", pingOk))
+	row, err := conn.QueryRowBuiltin(ctx, keyPing)
 	if err != nil {
 		return pingFailed, nil
 	}
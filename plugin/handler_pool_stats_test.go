@@ -0,0 +1,70 @@
+//go:build postgresql_tests
+// +build postgresql_tests
+
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestPlugin_poolStatsHandler(t *testing.T) {
+	sharedPool, err := getConnPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		ctx         context.Context
+		conn        *PGConn
+		key         string
+		params      map[string]string
+		extraParams []string
+	}
+	tests := []struct {
+		name    string
+		p       *Plugin
+		args    args
+		wantErr bool
+	}{
+		{
+			fmt.Sprintf("poolStatsHandler should return json with pool statistics"),
+			&Impl,
+			args{context.Background(), sharedPool, keyPoolStats, nil, []string{}},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := poolStatsHandler(tt.args.ctx, tt.args.conn, tt.args.key, tt.args.params, tt.args.extraParams...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Plugin.poolStatsHandler() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			var stats map[string]int64
+			if err = json.Unmarshal([]byte(got.(string)), &stats); err != nil {
+				t.Errorf("Plugin.poolStatsHandler() returned invalid JSON: %v", err)
+			}
+		})
+	}
+}
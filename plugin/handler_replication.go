@@ -27,20 +27,64 @@ import (
 	"github.com/jackc/pgx/v4"
 )
 
+const (
+	keyReplicationCount        = "pgsql.replication.count"
+	keyReplicationLagB         = "pgsql.replication.lag.b"
+	keyReplicationLagSec       = "pgsql.replication.lag.sec"
+	keyReplicationProcessInfo  = "pgsql.replication.process"
+	keyReplicationRecoveryRole = "pgsql.replication.recovery_role"
+	keyReplicationStatus       = "pgsql.replication.status"
+)
+
+var _ = registerMetric(keyReplicationCount, metricSpec{
+	description: "Returns number of standby servers.",
+	params:      commonParams,
+	handler:     replicationHandler,
+})
+
+var _ = registerMetric(keyReplicationLagB, metricSpec{
+	description: "Returns replication lag with Master in byte.",
+	params:      commonParams,
+	handler:     replicationHandler,
+})
+
+var _ = registerMetric(keyReplicationLagSec, metricSpec{
+	description: "Returns replication lag with Master in seconds.",
+	params:      commonParams,
+	handler:     replicationHandler,
+})
+
+var _ = registerMetric(keyReplicationProcessInfo, metricSpec{
+	description: "Returns flush lag, write lag and replay lag per each sender process.",
+	params:      commonParams,
+	handler:     replicationHandler,
+})
+
+var _ = registerMetric(keyReplicationRecoveryRole, metricSpec{
+	description: "Returns postgreSQL recovery role.",
+	params:      commonParams,
+	handler:     replicationHandler,
+})
+
+var _ = registerMetric(keyReplicationStatus, metricSpec{
+	description: "Returns postgreSQL replication status.",
+	params:      commonParams,
+	handler:     replicationHandler,
+})
+
 // replicationHandler gets info about recovery state if all is OK or nil otherwise.
 func replicationHandler(ctx context.Context, conn PostgresClient,
 	key string, _ map[string]string, _ ...string) (interface{}, error) {
 	var (
 		replicationResult int64
 		status            int
-		query             string
 		stringResult      sql.NullString
 		inRecovery        bool
 	)
 
 	switch key {
 	case keyReplicationStatus:
-		row, err := conn.QueryRow(ctx, `SELECT pg_is_in_recovery()`)
+		row, err := conn.QueryRowBuiltin(ctx, "pgsql.replication.in_recovery")
 		if err != nil {
 			return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
 		}
@@ -51,7 +95,7 @@ func replicationHandler(ctx context.Context, conn PostgresClient,
 		}
 
 		if inRecovery {
-			row, err = conn.QueryRow(ctx, `SELECT COUNT(*) FROM pg_stat_wal_receiver`)
+			row, err = conn.QueryRowBuiltin(ctx, keyReplicationStatus)
 			if err != nil {
 				return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
 			}
@@ -70,14 +114,8 @@ func replicationHandler(ctx context.Context, conn PostgresClient,
 
 		return strconv.Itoa(status), nil
 
-	case keyReplicationLagSec:
-		query = `SELECT
-					CASE
-		  				WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
-		  				ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())::integer, 0)
-					END AS lag;`
 	case keyReplicationLagB:
-		row, err := conn.QueryRow(ctx, `SELECT pg_is_in_recovery()`)
+		row, err := conn.QueryRowBuiltin(ctx, "pgsql.replication.in_recovery")
 		if err != nil {
 			return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
 		}
@@ -92,8 +130,7 @@ func replicationHandler(ctx context.Context, conn PostgresClient,
 		}
 
 		if inRecovery {
-			query = `SELECT pg_catalog.pg_wal_lsn_diff (pg_last_wal_receive_lsn(), pg_last_wal_replay_lsn());`
-			row, err = conn.QueryRow(ctx, query)
+			row, err = conn.QueryRowBuiltin(ctx, key)
 
 			if err != nil {
 				return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
@@ -113,23 +150,8 @@ func replicationHandler(ctx context.Context, conn PostgresClient,
 
 		return replicationResult, nil
 
-	case keyReplicationRecoveryRole:
-		query = `SELECT pg_is_in_recovery()::int`
-
-	case keyReplicationCount:
-		query = `SELECT COUNT(DISTINCT client_addr) + COALESCE(SUM(CASE WHEN client_addr IS NULL THEN 1 ELSE 0 END), 0) FROM pg_stat_replication;`
-
 	case keyReplicationProcessInfo:
-		query = `SELECT json_object_agg(application_name, row_to_json(T))
-				   FROM (
-						SELECT
-						    application_name,
-							EXTRACT(epoch FROM COALESCE(flush_lag,'0'::interval)) AS flush_lag, 
-							EXTRACT(epoch FROM COALESCE(replay_lag,'0'::interval)) AS replay_lag,
-							EXTRACT(epoch FROM COALESCE(write_lag, '0'::interval)) AS write_lag
-						FROM pg_stat_replication
-					) T; `
-		row, err := conn.QueryRow(ctx, query)
+		row, err := conn.QueryRowBuiltin(ctx, key)
 
 		if err != nil {
 			return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
@@ -147,7 +169,7 @@ func replicationHandler(ctx context.Context, conn PostgresClient,
 		return stringResult.String, nil
 	}
 
-	row, err := conn.QueryRow(ctx, query)
+	row, err := conn.QueryRowBuiltin(ctx, key)
 
 	if err != nil {
 		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
@@ -25,17 +25,21 @@ import (
 	"github.com/jackc/pgx/v4"
 )
 
+const keyReplicationProcessNameDiscovery = "pgsql.replication.process.discovery"
+
+var _ = registerMetric(keyReplicationProcessNameDiscovery, metricSpec{
+	description: "Returns JSON with application name from pg_stat_replication.",
+	params:      commonParams,
+	handler:     processNameDiscoveryHandler,
+})
+
 // processNameDiscoveryHandler gets names of all sender processes in pg_stat_replication
 // and returns JSON if all is OK or nil otherwise.
 func processNameDiscoveryHandler(ctx context.Context, conn PostgresClient,
 	_ string, _ map[string]string, _ ...string) (interface{}, error) {
 	var appNameJSON string
 
-	query := `SELECT 
-	json_build_object('data',COALESCE(json_agg(json_build_object('{#APPLICATION_NAME}',application_name)), '[]'))		
-	FROM pg_stat_replication`
-
-	row, err := conn.QueryRow(ctx, query)
+	row, err := conn.QueryRowBuiltin(ctx, keyReplicationProcessNameDiscovery)
 	if err != nil {
 		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
 	}
@@ -0,0 +1,70 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+
+	"git.zabbix.com/ap/plugin-support/zbxerr"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	keySlru          = "pgsql.slru"
+	keySlruDiscovery = "pgsql.slru.discovery"
+)
+
+var _ = registerMetric(keySlru, metricSpec{
+	description: "Returns JSON with statistics for each SLRU cache.",
+	params:      commonParams,
+	minVersion:  pgVersionWithSlru,
+	handler:     slruHandler,
+})
+
+var _ = registerMetric(keySlruDiscovery, metricSpec{
+	description: "Returns JSON discovery rule with names of SLRU caches.",
+	params:      commonParams,
+	minVersion:  pgVersionWithSlru,
+	handler:     slruHandler,
+})
+
+const pgVersionWithSlru = 130000
+
+// slruHandler executes select from pg_catalog.pg_stat_slru and returns JSON with statistics
+// per SLRU cache or LLD JSON with names of SLRU caches if all is OK or nil otherwise.
+func slruHandler(ctx context.Context, conn PostgresClient,
+	key string, _ map[string]string, _ ...string) (interface{}, error) {
+	var slruJSON string
+
+	row, err := conn.QueryRowBuiltin(ctx, key)
+	if err != nil {
+		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+	}
+
+	err = row.Scan(&slruJSON)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, zbxerr.ErrorEmptyResult.Wrap(err)
+		}
+
+		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+	}
+
+	return slruJSON, nil
+}
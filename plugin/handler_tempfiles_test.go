@@ -0,0 +1,135 @@
+//go:build postgresql_tests
+// +build postgresql_tests
+
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestPlugin_tempFilesHandler(t *testing.T) {
+	sharedPool, err := getConnPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		ctx         context.Context
+		conn        *PGConn
+		key         string
+		params      map[string]string
+		extraParams []string
+	}
+	tests := []struct {
+		name    string
+		p       *Plugin
+		args    args
+		wantErr bool
+	}{
+		{
+			fmt.Sprintf("tempFilesHandler should return json with data for pgsql.tempfiles key if OK"),
+			&Impl,
+			args{context.Background(), sharedPool, keyTempFiles, map[string]string{"TopN": "10"}, []string{}},
+			false,
+		},
+		{
+			fmt.Sprintf("tempFilesHandler should fail on a wrong TopN"),
+			&Impl,
+			args{context.Background(), sharedPool, keyTempFiles, map[string]string{"TopN": "top"}, []string{}},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tempFilesHandler(tt.args.ctx, tt.args.conn, tt.args.key, tt.args.params, tt.args.extraParams...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Plugin.tempFilesHandler() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+		})
+	}
+}
+
+func TestPlugin_tempFilesHandler_deltas(t *testing.T) {
+	sharedPool, err := getConnPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := map[string]string{"TopN": "10"}
+
+	if _, err = tempFilesHandler(context.Background(), sharedPool, keyTempFiles, params); err != nil {
+		t.Fatalf("Plugin.tempFilesHandler() error = %v", err)
+	}
+
+	got, err := tempFilesHandler(context.Background(), sharedPool, keyTempFiles, params)
+	if err != nil {
+		t.Fatalf("Plugin.tempFilesHandler() error = %v", err)
+	}
+
+	var res struct {
+		Databases map[string]tempFilesStat `json:"databases"`
+	}
+
+	if err = json.Unmarshal([]byte(got.(string)), &res); err != nil {
+		t.Fatalf("Plugin.tempFilesHandler() returned invalid JSON %s: %v", got, err)
+	}
+
+	if len(res.Databases) == 0 {
+		t.Fatalf("Plugin.tempFilesHandler() returned no databases")
+	}
+
+	for dbname, stat := range res.Databases {
+		if stat.TempFilesDelta == nil || stat.TempBytesDelta == nil {
+			t.Errorf("Plugin.tempFilesHandler() returned no deltas for %s on the second call", dbname)
+		}
+	}
+}
+
+func Test_tempFilesCounters_update(t *testing.T) {
+	var counters tempFilesCounters
+
+	first := counters.update(map[string]tempFilesStat{"zabbix": {TempFiles: 2, TempBytes: 100}})
+	if first["zabbix"].TempFilesDelta != nil || first["zabbix"].TempBytesDelta != nil {
+		t.Errorf("tempFilesCounters.update() = %+v, want no deltas on the first poll", first["zabbix"])
+	}
+
+	second := counters.update(map[string]tempFilesStat{
+		"zabbix": {TempFiles: 5, TempBytes: 400},
+		"app":    {TempFiles: 1, TempBytes: 10},
+	})
+
+	if got := second["zabbix"]; got.TempFilesDelta == nil || *got.TempFilesDelta != 3 ||
+		got.TempBytesDelta == nil || *got.TempBytesDelta != 300 {
+		t.Errorf("tempFilesCounters.update() = %+v, want deltas 3 and 300", got)
+	}
+
+	if got := second["app"]; got.TempFilesDelta != nil {
+		t.Errorf("tempFilesCounters.update() = %+v, want no deltas for a new database", got)
+	}
+
+	third := counters.update(map[string]tempFilesStat{"zabbix": {TempFiles: 1, TempBytes: 50}})
+	if got := third["zabbix"]; got.TempFilesDelta == nil || *got.TempFilesDelta != 1 {
+		t.Errorf("tempFilesCounters.update() = %+v, want delta 1 after a reset", got)
+	}
+}
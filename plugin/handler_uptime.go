@@ -25,14 +25,21 @@ import (
 	"github.com/jackc/pgx/v4"
 )
 
+const keyUptime = "pgsql.uptime"
+
+var _ = registerMetric(keyUptime, metricSpec{
+	description: "Returns uptime.",
+	params:      commonParams,
+	handler:     uptimeHandler,
+})
+
 // uptimeHandler finds difference btw current time and
 // postmaster start time and returns int64 if all is OK or nil otherwise.
 func uptimeHandler(ctx context.Context, conn PostgresClient,
 	_ string, _ map[string]string, _ ...string) (interface{}, error) {
 	var uptime float64
 
-	query := `SELECT date_part('epoch', now() - pg_postmaster_start_time());`
-	row, err := conn.QueryRow(ctx, query)
+	row, err := conn.QueryRowBuiltin(ctx, keyUptime)
 
 	if err != nil {
 		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
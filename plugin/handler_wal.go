@@ -25,27 +25,20 @@ import (
 	"github.com/jackc/pgx/v4"
 )
 
+const keyWal = "pgsql.wal.stat"
+
+var _ = registerMetric(keyWal, metricSpec{
+	description: "Returns JSON wal by type.",
+	params:      commonParams,
+	handler:     walHandler,
+})
+
 // walHandler executes select from directory which contains wal files and returns JSON if all is OK or nil otherwise.
 func walHandler(ctx context.Context, conn PostgresClient,
 	_ string, _ map[string]string, _ ...string) (interface{}, error) {
 	var walJSON string
 
-	query := `SELECT row_to_json(T)
-			    FROM (
-					SELECT
-						CASE
-							WHEN pg_is_in_recovery() THEN 0
-							ELSE pg_wal_lsn_diff(pg_current_wal_lsn(),'0/00000000')
-						END AS WRITE,
-						CASE 
-							WHEN NOT pg_is_in_recovery() THEN 0
-							ELSE pg_wal_lsn_diff(pg_last_wal_receive_lsn(),'0/00000000')
-						END AS RECEIVE,
-						count(*)
-						FROM pg_ls_waldir() AS COUNT
-					) T;`
-
-	row, err := conn.QueryRow(ctx, query)
+	row, err := conn.QueryRowBuiltin(ctx, keyWal)
 	if err != nil {
 		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
 	}
@@ -18,7 +18,6 @@
 package plugin
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"regexp"
@@ -29,90 +28,6 @@ import (
 	"git.zabbix.com/ap/plugin-support/uri"
 )
 
-const (
-	keyArchiveSize                     = "pgsql.archive"
-	keyAutovacuum                      = "pgsql.autovacuum.count"
-	keyBgwriter                        = "pgsql.bgwriter"
-	keyCache                           = "pgsql.cache.hit"
-	keyConnections                     = "pgsql.connections"
-	keyCustomQuery                     = "pgsql.custom.query"
-	keyDBStat                          = "pgsql.dbstat"
-	keyDBStatSum                       = "pgsql.dbstat.sum"
-	keyDatabaseAge                     = "pgsql.db.age"
-	keyDatabasesBloating               = "pgsql.db.bloating_tables"
-	keyDatabasesDiscovery              = "pgsql.db.discovery"
-	keyDatabaseSize                    = "pgsql.db.size"
-	keyLocks                           = "pgsql.locks"
-	keyOldestXid                       = "pgsql.oldest.xid"
-	keyPing                            = "pgsql.ping"
-	keyQueries                         = "pgsql.queries"
-	keyReplicationCount                = "pgsql.replication.count"
-	keyReplicationLagB                 = "pgsql.replication.lag.b"
-	keyReplicationLagSec               = "pgsql.replication.lag.sec"
-	keyReplicationProcessInfo          = "pgsql.replication.process"
-	keyReplicationProcessNameDiscovery = "pgsql.replication.process.discovery"
-	keyReplicationRecoveryRole         = "pgsql.replication.recovery_role"
-	keyReplicationStatus               = "pgsql.replication.status"
-	keyUptime                          = "pgsql.uptime"
-	keyWal                             = "pgsql.wal.stat"
-)
-
-// handlerFunc defines an interface must be implemented by handlers.
-type handlerFunc func(ctx context.Context, conn PostgresClient, key string,
-	params map[string]string, extraParams ...string) (res interface{}, err error)
-
-// getHandlerFunc returns a handlerFunc related to a given key.
-func getHandlerFunc(key string) handlerFunc {
-	switch key {
-	case keyDatabasesDiscovery:
-		return databasesDiscoveryHandler
-	case keyDatabasesBloating:
-		return databasesBloatingHandler
-	case keyDatabaseSize:
-		return databaseSizeHandler
-	case keyDatabaseAge:
-		return databaseAgeHandler
-	case keyArchiveSize:
-		return archiveHandler
-	case keyPing:
-		return pingHandler
-	case keyConnections:
-		return connectionsHandler
-	case keyWal:
-		return walHandler
-	case keyAutovacuum:
-		return autovacuumHandler
-	case keyDBStat,
-		keyDBStatSum:
-		return dbStatHandler
-	case keyBgwriter:
-		return bgwriterHandler
-	case keyCustomQuery:
-		return customQueryHandler
-	case keyUptime:
-		return uptimeHandler
-	case keyCache:
-		return cacheHandler
-	case keyReplicationCount,
-		keyReplicationStatus,
-		keyReplicationLagSec,
-		keyReplicationRecoveryRole,
-		keyReplicationLagB,
-		keyReplicationProcessInfo:
-		return replicationHandler
-	case keyReplicationProcessNameDiscovery:
-		return processNameDiscoveryHandler
-	case keyLocks:
-		return locksHandler
-	case keyOldestXid:
-		return oldestXIDHandler
-	case keyQueries:
-		return queriesHandler
-	default:
-		return nil
-	}
-}
-
 var uriDefaults = &uri.Defaults{Scheme: "tcp", Port: "5432"}
 
 var (
@@ -128,12 +43,27 @@ type PostgresURIValidator struct {
 
 var reSocketPath = regexp.MustCompile(`^.*\.s\.PGSQL\.\d{1,5}$`)
 
+// Validate checks a single address or a comma-separated list of addresses of a multi-host cluster.
 func (v PostgresURIValidator) Validate(value *string) error {
 	if value == nil {
 		return nil
 	}
 
-	u, err := uri.New(*value, v.Defaults)
+	for _, addr := range strings.Split(*value, ",") {
+		if err := v.validateAddr(strings.TrimSpace(addr)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v PostgresURIValidator) validateAddr(addr string) error {
+	if addr == "" {
+		return errors.New("empty host address")
+	}
+
+	u, err := uri.New(addr, v.Defaults)
 	if err != nil {
 		return err
 	}
@@ -152,7 +82,7 @@ func (v PostgresURIValidator) Validate(value *string) error {
 		}
 	}
 
-	if u.Scheme() == "unix" && !reSocketPath.MatchString(*value) {
+	if u.Scheme() == "unix" && !reSocketPath.MatchString(addr) {
 		return errors.New(
 			`socket file must satisfy the format: "/path/.s.PGSQL.nnnn" where nnnn is the server's port number`)
 	}
@@ -179,111 +109,24 @@ var (
 	paramTLSKeyFile  = metric.NewSessionOnlyParam("TLSKeyFile", "TLS key file path.").WithDefault("")
 )
 
-var metrics = metric.MetricSet{
-	keyArchiveSize: metric.New("Returns info about size of archive files.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyAutovacuum: metric.New("Returns count of autovacuum workers.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyBgwriter: metric.New("Returns JSON for sum of each type of bgwriter statistic.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyCache: metric.New("Returns cache hit percent.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyConnections: metric.New("Returns JSON for sum of each type of connection.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyCustomQuery: metric.New("Returns result of a custom query.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase,
-			metric.NewParam("QueryName", "Name of a custom query "+
-				"(must be equal to a name of an SQL file without an extension).").SetRequired(),
-			paramTLSConnect, paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, true),
-
-	keyDBStat: metric.New("Returns JSON for sum of each type of statistic.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyDBStatSum: metric.New("Returns JSON for sum of each type of statistic for all database.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyDatabaseAge: metric.New("Returns age for specific database.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyDatabasesBloating: metric.New("Returns percent of bloating tables for each database.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyDatabasesDiscovery: metric.New("Returns JSON discovery rule with names of databases.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyDatabaseSize: metric.New("Returns size in bytes for specific database.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyLocks: metric.New("Returns collect all metrics from pg_locks.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyOldestXid: metric.New("Returns age of oldest xid.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyPing: metric.New("Tests if connection is alive or not.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyQueries: metric.New("Returns queries statistic.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase,
-			metric.NewParam("TimePeriod", "Execution time limit for count of slow queries.").SetRequired(),
-			paramTLSConnect, paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyReplicationCount: metric.New("Returns number of standby servers.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyReplicationLagB: metric.New("Returns replication lag with Master in byte.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyReplicationLagSec: metric.New("Returns replication lag with Master in seconds.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyReplicationProcessNameDiscovery: metric.New("Returns JSON with application name from pg_stat_replication.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyReplicationProcessInfo: metric.New("Returns flush lag, write lag and replay lag per each sender process.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
-
-	keyReplicationRecoveryRole: metric.New("Returns postgreSQL recovery role.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
+// leadingParams are common parameters preceding key specific ones, trailingParams follow them.
+var (
+	leadingParams  = []*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase}
+	trailingParams = []*metric.Param{paramTLSConnect, paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}
+)
 
-	keyReplicationStatus: metric.New("Returns postgreSQL replication status.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
+// commonParams are parameters of most of the keys.
+var commonParams = paramsWith()
 
-	keyUptime: metric.New("Returns uptime.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
+// paramsWith returns commonParams with key specific parameters placed after Database.
+func paramsWith(extra ...*metric.Param) []*metric.Param {
+	params := make([]*metric.Param, 0, len(leadingParams)+len(extra)+len(trailingParams))
+	params = append(params, leadingParams...)
+	params = append(params, extra...)
 
-	keyWal: metric.New("Returns JSON wal by type.",
-		[]*metric.Param{paramURI, paramUsername, paramPassword, paramDatabase, paramTLSConnect,
-			paramTLSCaFile, paramTLSCertFile, paramTLSKeyFile}, false),
+	return append(params, trailingParams...)
 }
 
 func init() {
-	plugin.RegisterMetrics(&Impl, Name, metrics.List()...)
+	plugin.RegisterMetrics(&Impl, Name, metricSet().List()...)
 }
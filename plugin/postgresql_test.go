@@ -75,9 +75,9 @@ func TestPlugin_Export(t *testing.T) {
 	}
 
 	//Impl.Configure(&plugin.GlobalOptions{Timeout: 30}, nil)
-	Impl.connMgr.queryStorage = yarn.NewFromMap(map[string]string{
+	Impl.connMgr.queries.set(yarn.NewFromMap(map[string]string{
 		"TestQuery.sql": "SELECT $1::text AS res",
-	})
+	}), builtinCatalogue)
 
 	tests := []struct {
 		name       string
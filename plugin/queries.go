@@ -0,0 +1,511 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"git.zabbix.com/ap/plugin-support/plugin"
+	"git.zabbix.com/ap/plugin-support/zbxerr"
+	"github.com/omeid/go-yarn"
+)
+
+const keyCustomQueryStatus = "pgsql.custom.query.status"
+
+var _ = registerMetric(keyCustomQueryStatus, metricSpec{
+	description: "Returns JSON with the state of the custom queries directory: loaded queries, reloads and " +
+		"files which failed to load.",
+	pluginHandler: func(p *Plugin) (interface{}, error) { return p.queryLoader.statusJSON() },
+})
+
+const keyCustomQueryList = "pgsql.custom.query.list"
+
+var _ = registerMetric(keyCustomQueryList, metricSpec{
+	description:   "Returns JSON with the loaded custom queries and their metadata.",
+	pluginHandler: func(p *Plugin) (interface{}, error) { return p.connMgr.queries.listJSON() },
+})
+
+// queryStore holds custom queries and the catalogue of built-in queries. Both are replaced at once when
+// the custom queries directory is reloaded, queries which are already running are not affected.
+// A nil store has no custom queries and the default built-in ones.
+type queryStore struct {
+	mutex     sync.RWMutex
+	policy    queryPolicy
+	storage   yarn.Yarn
+	queries   map[string]*customQuery
+	rejected  map[string]error
+	catalogue *sqlCatalogue
+}
+
+func newQueryStore(storage yarn.Yarn, catalogue *sqlCatalogue, policy queryPolicy) *queryStore {
+	s := &queryStore{policy: policy}
+	s.set(storage, catalogue)
+
+	return s
+}
+
+// parse parses a custom query and applies the policy of the store to it.
+func (s *queryStore) parse(name, text string) (*customQuery, error) {
+	q, err := parseCustomQuery(name, text)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.policy.apply(q); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// numericPrecision returns the precision results are rounded to, a nil store does not round them.
+func (s *queryStore) numericPrecision() int {
+	if s == nil {
+		return -1
+	}
+
+	return s.policy.numericPrecision
+}
+
+// set replaces custom queries and the catalogue of built-in queries. Files which cannot be parsed are
+// rejected, the loader reports them before they get here.
+func (s *queryStore) set(storage yarn.Yarn, catalogue *sqlCatalogue) {
+	queries := make(map[string]*customQuery)
+	rejected := make(map[string]error)
+
+	if storage != nil {
+		for file, text := range storage.All() {
+			if !strings.HasSuffix(file, sqlExt) {
+				continue
+			}
+
+			name := strings.TrimSuffix(file, sqlExt)
+
+			q, err := s.parse(name, text)
+			if err != nil {
+				rejected[name] = err
+
+				continue
+			}
+
+			queries[name] = q
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.storage = storage
+	s.queries = queries
+	s.rejected = rejected
+	s.catalogue = catalogue
+}
+
+// reject records errors of files which failed to load, by query names.
+func (s *queryStore) reject(errs map[string]error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.rejected = errs
+}
+
+// lookup returns a custom query by its name or an error telling why it is not available.
+func (s *queryStore) lookup(name string) (*customQuery, error) {
+	if q, ok := s.customQuery(name); ok {
+		return q, nil
+	}
+
+	if s != nil {
+		s.mutex.RLock()
+		err, ok := s.rejected[name]
+		s.mutex.RUnlock()
+
+		if ok {
+			return nil, errorQueryRejected.Wrap(fmt.Errorf("query %s failed to load: %s", name, err.Error()))
+		}
+	}
+
+	return nil, zbxerr.ErrorCannotFetchData.Wrap(fmt.Errorf(errorQueryNotFound, name))
+}
+
+// customQuery returns a custom query by its name.
+func (s *queryStore) customQuery(name string) (*customQuery, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	q, ok := s.queries[name]
+
+	return q, ok
+}
+
+// customQueryList returns all custom queries sorted by name.
+func (s *queryStore) customQueryList() []*customQuery {
+	if s == nil {
+		return nil
+	}
+
+	s.mutex.RLock()
+	res := make([]*customQuery, 0, len(s.queries))
+
+	for _, q := range s.queries {
+		res = append(res, q)
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+
+	return res
+}
+
+// builtinQuery returns the variant of a built-in query for a given server version. A variant overridden
+// in the custom queries directory is guarded, i.e. it must be executed read-only, if the policy requires
+// custom queries to be read-only.
+func (s *queryStore) builtinQuery(name string, version int) (query string, guarded bool, err error) {
+	catalogue := builtinCatalogue
+	readOnly := false
+
+	if s != nil {
+		s.mutex.RLock()
+		if s.catalogue != nil {
+			catalogue = s.catalogue
+		}
+		s.mutex.RUnlock()
+
+		readOnly = s.policy.readOnly
+	}
+
+	v, err := catalogue.variant(name, version)
+	if err != nil {
+		return "", false, err
+	}
+
+	return v.text(), v.override && readOnly, nil
+}
+
+// queryFile is a loaded version of a file of the custom queries directory, the file is considered
+// unchanged while its modification time and size stay the same.
+type queryFile struct {
+	modTime time.Time
+	size    int64
+	sql     string
+	err     error
+}
+
+func (f queryFile) sameAs(info os.FileInfo) bool {
+	return f.modTime.Equal(info.ModTime()) && f.size == info.Size()
+}
+
+// queryLoader loads the custom queries directory into a queryStore and reloads files which have changed
+// since the previous check. A file which fails to load does not replace its previous valid version,
+// so a broken edit does not break items using the query.
+type queryLoader struct {
+	mutex      sync.Mutex
+	path       string
+	store      *queryStore
+	logger     plugin.Logger
+	now        func() time.Time
+	valid      map[string]queryFile
+	failed     map[string]queryFile
+	dirErr     error
+	reloads    int64
+	lastCheck  time.Time
+	lastReload time.Time
+}
+
+func newQueryLoader(path string, store *queryStore, logger plugin.Logger) *queryLoader {
+	return &queryLoader{
+		path:   path,
+		store:  store,
+		logger: logger,
+		now:    time.Now,
+		valid:  make(map[string]queryFile),
+		failed: make(map[string]queryFile),
+	}
+}
+
+// load checks the directory for new, changed and removed files and updates the store if any of
+// them affects the loaded queries. The first load always updates the store.
+func (l *queryLoader) load() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	first := l.lastCheck.IsZero()
+	l.lastCheck = l.now()
+
+	entries, err := os.ReadDir(l.path)
+	if err != nil {
+		if l.dirErr == nil || l.dirErr.Error() != err.Error() {
+			l.logger.Errf("[%s] Cannot read custom queries directory: %s", Name, err.Error())
+		}
+
+		l.dirErr = err
+
+		// Keep the queries loaded so far, the directory may be temporarily unavailable.
+		if first {
+			l.apply()
+		}
+
+		return
+	}
+
+	l.dirErr = nil
+
+	changed := first
+	valid := make(map[string]queryFile, len(entries))
+	failed := make(map[string]queryFile)
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), sqlExt) {
+			continue
+		}
+
+		file := e.Name()
+
+		info, err := e.Info()
+		if err != nil {
+			// The file has been removed after the directory was read.
+			continue
+		}
+
+		if prev, ok := l.valid[file]; ok && prev.sameAs(info) {
+			valid[file] = prev
+
+			continue
+		}
+
+		if prev, ok := l.failed[file]; ok && prev.sameAs(info) {
+			failed[file] = prev
+
+			if prev, ok := l.valid[file]; ok {
+				valid[file] = prev
+			}
+
+			continue
+		}
+
+		f := l.readFile(file, info)
+		if f.err != nil {
+			l.logger.Errf("[%s] Cannot load custom query %s: %s", Name, file, f.err.Error())
+			failed[file] = f
+
+			if prev, ok := l.valid[file]; ok {
+				l.logger.Warningf("[%s] Previous version of custom query %s is kept", Name, file)
+				valid[file] = prev
+			}
+
+			continue
+		}
+
+		valid[file] = f
+		changed = true
+	}
+
+	for file := range l.valid {
+		if _, ok := valid[file]; !ok {
+			l.logger.Infof("[%s] Custom query %s is removed", Name, file)
+			changed = true
+		}
+	}
+
+	l.valid = valid
+	l.failed = failed
+
+	if changed {
+		l.apply()
+	}
+
+	errs := make(map[string]error, len(failed))
+	for file, f := range failed {
+		errs[strings.TrimSuffix(file, sqlExt)] = f.err
+	}
+
+	l.store.reject(errs)
+}
+
+// readFile reads and validates a file of the directory.
+func (l *queryLoader) readFile(file string, info os.FileInfo) queryFile {
+	f := queryFile{modTime: info.ModTime(), size: info.Size()}
+
+	b, err := os.ReadFile(filepath.Join(l.path, file))
+	if err != nil {
+		f.err = err
+
+		return f
+	}
+
+	f.sql = string(b)
+	_, f.err = l.store.parse(strings.TrimSuffix(file, sqlExt), f.sql)
+
+	return f
+}
+
+// apply replaces the queries of the store with the valid files. The caller must hold the mutex.
+func (l *queryLoader) apply() {
+	files := make(map[string]string, len(l.valid))
+	for file, f := range l.valid {
+		files[file] = f.sql
+	}
+
+	catalogue, overrides, replaced := builtinCatalogue.withOverrides(files)
+	for _, file := range overrides {
+		l.logger.Infof("[%s] Built-in query is overridden by %s", Name, file)
+	}
+
+	for _, file := range replaced {
+		l.logger.Infof("[%s] Built-in query %s is replaced by an override", Name, file)
+	}
+
+	l.store.set(yarn.NewFromMap(files), catalogue)
+
+	if l.reloads > 0 {
+		l.logger.Infof("[%s] Custom queries are reloaded: %d loaded, %d failed", Name, len(files), len(l.failed))
+	}
+
+	l.reloads++
+	l.lastReload = l.lastCheck
+}
+
+// run reloads the directory every interval until stop is closed.
+func (l *queryLoader) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			l.load()
+		}
+	}
+}
+
+// queryLoaderStatus is a JSON representation of the state of the custom queries directory,
+// times are Unix timestamps.
+type queryLoaderStatus struct {
+	Path       string            `json:"path"`
+	Queries    []string          `json:"queries"`
+	Errors     map[string]string `json:"errors"`
+	Reloads    int64             `json:"reloads"`
+	LastCheck  int64             `json:"last_check"`
+	LastReload int64             `json:"last_reload"`
+}
+
+// status returns the state of the directory. Queries of a nil loader are not configured.
+func (l *queryLoader) status() queryLoaderStatus {
+	res := queryLoaderStatus{Queries: []string{}, Errors: map[string]string{}}
+
+	if l == nil {
+		return res
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	res.Path = l.path
+	res.Reloads = l.reloads
+
+	for file := range l.valid {
+		res.Queries = append(res.Queries, strings.TrimSuffix(file, sqlExt))
+	}
+
+	sort.Strings(res.Queries)
+
+	for file, f := range l.failed {
+		res.Errors[file] = f.err.Error()
+	}
+
+	if l.dirErr != nil {
+		res.Errors[l.path] = l.dirErr.Error()
+	}
+
+	if !l.lastCheck.IsZero() {
+		res.LastCheck = l.lastCheck.Unix()
+	}
+
+	if !l.lastReload.IsZero() {
+		res.LastReload = l.lastReload.Unix()
+	}
+
+	return res
+}
+
+func (l *queryLoader) statusJSON() (interface{}, error) {
+	jsonRes, err := json.Marshal(l.status())
+	if err != nil {
+		return nil, zbxerr.ErrorCannotMarshalJSON.Wrap(err)
+	}
+
+	return string(jsonRes), nil
+}
+
+// customQueryJSON is a JSON representation of a custom query and its metadata. Versions are major versions,
+// zero means no limit.
+type customQueryJSON struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Timeout     int          `json:"timeout"`
+	Output      string       `json:"output"`
+	Params      []queryParam `json:"params"`
+	MinVersion  int          `json:"min_version"`
+	MaxVersion  int          `json:"max_version"`
+	Extension   string       `json:"extension"`
+	ReadOnly    bool         `json:"readonly"`
+}
+
+// listJSON returns JSON with all custom queries sorted by name.
+func (s *queryStore) listJSON() (interface{}, error) {
+	res := make([]customQueryJSON, 0)
+
+	for _, q := range s.customQueryList() {
+		params := q.params
+		if params == nil {
+			params = []queryParam{}
+		}
+
+		res = append(res, customQueryJSON{
+			Name:        q.name,
+			Description: q.description,
+			Timeout:     int(q.timeout / time.Second),
+			Output:      q.output,
+			Params:      params,
+			MinVersion:  q.minVersion / 10000,
+			MaxVersion:  q.maxVersion / 10000,
+			Extension:   q.extension,
+			ReadOnly:    q.isReadOnly(),
+		})
+	}
+
+	jsonRes, err := json.Marshal(res)
+	if err != nil {
+		return nil, zbxerr.ErrorCannotMarshalJSON.Wrap(err)
+	}
+
+	return string(jsonRes), nil
+}
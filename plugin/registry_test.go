@@ -0,0 +1,95 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_registry(t *testing.T) {
+	for key, m := range registry {
+		if (m.handler == nil) == (m.pluginHandler == nil) {
+			t.Errorf("key %s must have either a handler or a plugin handler", key)
+		}
+
+		if m.metric == nil {
+			t.Errorf("key %s has no metric", key)
+		}
+
+		if m.minVersion != 0 && m.maxVersion != 0 && m.minVersion > m.maxVersion {
+			t.Errorf("key %s has empty version range %d-%d", key, m.minVersion, m.maxVersion)
+		}
+	}
+
+	if len(metricSet()) != len(registry) {
+		t.Errorf("metricSet() has %d keys, want %d", len(metricSet()), len(registry))
+	}
+}
+
+func Test_registerMetric(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registerMetric() did not panic on a duplicate key")
+		}
+	}()
+
+	registerMetric(keyPing, metricSpec{description: "Duplicate.", params: commonParams, handler: pingHandler})
+}
+
+func Test_registeredMetric_checkVersion(t *testing.T) {
+	m := &registeredMetric{metricSpec: metricSpec{minVersion: 130000, maxVersion: 169999}}
+
+	tests := []struct {
+		name    string
+		m       *registeredMetric
+		version int
+		wantErr string
+	}{
+		{"unlimited", &registeredMetric{}, 90600, ""},
+		{"in range", m, 130000, ""},
+		{"upper bound", m, 160004, ""},
+		{"too old", m, 120010, "pgsql.test requires PostgreSQL 13 or newer, server version is 12"},
+		{"too old pre-10", m, 90624, "pgsql.test requires PostgreSQL 13 or newer, server version is 9.6"},
+		{"too new", m, 170000, "pgsql.test is not supported since PostgreSQL 17, server version is 17"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.m.checkVersion("pgsql.test", tt.version)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("registeredMetric.checkVersion() error = %v, want nil", err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.HasSuffix(err.Error(), tt.wantErr) {
+				t.Errorf("registeredMetric.checkVersion() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_paramsWith(t *testing.T) {
+	params := paramsWith(paramTLSConnect)
+
+	if len(params) != len(commonParams)+1 || params[4] != paramTLSConnect || params[5] != paramTLSConnect {
+		t.Errorf("paramsWith() = %v, want the extra parameter after Database", params)
+	}
+}
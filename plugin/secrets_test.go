@@ -0,0 +1,140 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.zabbix.com/ap/plugin-support/uri"
+)
+
+func Test_secretStore_resolve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PG_MONITOR_PASSWORD", "from env")
+
+	s := newSecretStore(time.Minute, 10*time.Second)
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	tests := []struct {
+		name         string
+		prepare      func()
+		ref          string
+		wantValue    string
+		wantPrevious string
+		wantErr      bool
+	}{
+		{"literal", nil, "secret", "secret", "secret", false},
+		{"file", nil, "file:" + path, "first", "", false},
+		{"unchanged file", nil, "file:" + path, "first", "first", false},
+		{
+			"changed file",
+			func() {
+				if err := os.WriteFile(path, []byte("second value\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			"file:" + path, "second value", "first", false,
+		},
+		{"missing file", nil, "file:" + path + ".missing", "", "", true},
+		{"env", nil, "env:PG_MONITOR_PASSWORD", "from env", "", false},
+		{"unset env", nil, "env:PG_MONITOR_UNSET_PASSWORD", "", "", true},
+		{"exec", nil, "exec:echo from helper", "from helper", "", false},
+		{
+			"cached exec",
+			func() { s.execs["echo from helper"].value = "cached" },
+			"exec:echo from helper", "cached", "from helper", false,
+		},
+		{
+			"expired exec",
+			func() { now = now.Add(2 * time.Minute) },
+			"exec:echo from helper", "from helper", "cached", false,
+		},
+		{"failed exec", nil, "exec:" + path + ".missing", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.prepare != nil {
+				tt.prepare()
+			}
+
+			value, previous, err := s.resolve(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("secretStore.resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if value != tt.wantValue || previous != tt.wantPrevious {
+				t.Errorf("secretStore.resolve() = %q, %q, want %q, %q", value, previous, tt.wantValue, tt.wantPrevious)
+			}
+		})
+	}
+}
+
+func TestPlugin_resolveSecrets(t *testing.T) {
+	d := &fakeDialer{started: make(chan string, 10)}
+	t.Setenv("PG_MONITOR_PASSWORD", "old")
+
+	p := &Plugin{
+		connMgr: newTestConnManager(t, d),
+		secrets: newSecretStore(time.Minute, 10*time.Second),
+		options: PluginOptions{Sessions: map[string]Session{"Prod": {Password: "env:PG_MONITOR_PASSWORD"}}},
+	}
+
+	connect := func() uri.URI {
+		params := map[string]string{"sessionName": "Prod", "User": "postgres", "Password": "env:PG_MONITOR_PASSWORD"}
+		if err := p.resolveSecrets(params); err != nil {
+			t.Fatal(err)
+		}
+
+		u, err := uri.NewWithCreds("tcp://192.0.2.1:5432?dbname=postgres", params["User"], params["Password"],
+			uriDefaults)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := p.connMgr.GetConnection(*u, tlsOptions{}, connOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		return *u
+	}
+
+	oldURI := connect()
+
+	t.Setenv("PG_MONITOR_PASSWORD", "new")
+	newURI := connect()
+
+	p.connMgr.connMutex.Lock()
+	defer p.connMgr.connMutex.Unlock()
+
+	if _, ok := p.connMgr.connections[connKey{uri: oldURI}]; ok {
+		t.Errorf("Plugin.resolveSecrets() connection with the old password is not closed")
+	}
+
+	if _, ok := p.connMgr.connections[connKey{uri: newURI}]; !ok {
+		t.Errorf("Plugin.resolveSecrets() connection with the new password is not established")
+	}
+}
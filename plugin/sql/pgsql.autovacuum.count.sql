@@ -0,0 +1,5 @@
+SELECT count(*)
+  FROM pg_catalog.pg_stat_activity
+ WHERE query like '%autovacuum%'
+   AND state <> 'idle'
+   AND application_name <> pg_catalog.current_setting('application_name');
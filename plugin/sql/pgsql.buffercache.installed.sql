@@ -0,0 +1 @@
+SELECT (SELECT extversion FROM pg_catalog.pg_extension WHERE extname = 'pg_buffercache');
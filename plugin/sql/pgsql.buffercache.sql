@@ -0,0 +1,34 @@
+SELECT row_to_json(T)
+  FROM (
+	SELECT S.*,
+		(SELECT json_object_agg(usagecount, buffers ORDER BY usagecount)
+			FROM (
+				SELECT coalesce(usagecount, 0) AS usagecount, count(*) AS buffers
+				FROM pg_buffercache
+				GROUP BY 1
+			) U) AS usage_counts,
+		(
+			SELECT COALESCE(json_agg(R), '[]')
+			FROM (
+				SELECT
+					c.oid::regclass::text AS relation,
+					count(*) AS buffers,
+					count(*) FILTER (WHERE b.isdirty) AS buffers_dirty
+				FROM pg_buffercache b
+				JOIN pg_catalog.pg_class c ON b.relfilenode = pg_catalog.pg_relation_filenode(c.oid)
+				WHERE b.reldatabase IN (0, (SELECT oid FROM pg_catalog.pg_database
+											 WHERE datname = pg_catalog.current_database()))
+					AND $1 > 0
+				GROUP BY c.oid
+				ORDER BY buffers DESC
+				LIMIT $1
+			) R
+		) AS top_relations
+	FROM (SELECT
+			count(*) FILTER (WHERE relfilenode IS NOT NULL) AS buffers_used,
+			count(*) FILTER (WHERE relfilenode IS NULL) AS buffers_unused,
+			count(*) FILTER (WHERE isdirty) AS buffers_dirty,
+			count(*) FILTER (WHERE pinning_backends > 0) AS buffers_pinned,
+			round(avg(usagecount), 2) AS usagecount_avg
+		FROM pg_buffercache) S
+) T;
@@ -0,0 +1,4 @@
+SELECT age(datfrozenxid)
+  FROM pg_catalog.pg_database
+ WHERE datistemplate = false
+   AND datname = $1;
@@ -0,0 +1,4 @@
+SELECT count(*)
+  FROM pg_catalog.pg_stat_all_tables
+ WHERE (n_dead_tup/(n_live_tup+n_dead_tup)::float8) > 0.2
+   AND (n_live_tup+n_dead_tup) > 50;
@@ -0,0 +1,4 @@
+SELECT json_build_object ('data',json_agg(json_build_object('{#DBNAME}',d.datname)))
+  FROM pg_database d
+ WHERE NOT datistemplate
+   AND datallowconn;
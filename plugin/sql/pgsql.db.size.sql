@@ -0,0 +1,4 @@
+SELECT pg_database_size(datname::text)
+  FROM pg_catalog.pg_database
+ WHERE datistemplate = false
+   AND datname = $1;
@@ -0,0 +1,3 @@
+SELECT e.extname, e.extversion, a.default_version
+  FROM pg_catalog.pg_extension e
+  LEFT JOIN pg_catalog.pg_available_extensions a ON a.name = e.extname;
@@ -0,0 +1,7 @@
+SELECT json_build_object('data', COALESCE(json_agg(json_build_object(
+           '{#PARTITIONED_TABLE}', format('%I.%I', n.nspname, c.relname),
+           '{#SCHEMA}', n.nspname,
+           '{#TABLE}', c.relname)), '[]'))
+  FROM pg_catalog.pg_partitioned_table p
+  JOIN pg_catalog.pg_class c ON c.oid = p.partrelid
+  JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace;
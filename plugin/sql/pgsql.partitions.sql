@@ -0,0 +1,66 @@
+WITH parents AS (
+	SELECT
+		p.partrelid AS oid,
+		format('%I.%I', n.nspname, c.relname) AS name,
+		p.partstrat,
+		p.partnatts,
+		(SELECT a.atttypid
+		   FROM pg_catalog.pg_attribute a
+		  WHERE a.attrelid = p.partrelid
+			AND a.attnum = p.partattrs[0]) AS keytype
+	FROM pg_catalog.pg_partitioned_table p
+	JOIN pg_catalog.pg_class c ON c.oid = p.partrelid
+	JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+), parts AS (
+	SELECT
+		i.inhparent AS parent,
+		c.oid,
+		format('%I.%I', n.nspname, c.relname) AS name,
+		pg_catalog.pg_get_expr(c.relpartbound, c.oid) AS bound,
+		c.reltuples
+	FROM pg_catalog.pg_inherits i
+	JOIN pg_catalog.pg_class c ON c.oid = i.inhrelid
+	JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relispartition
+), bounds AS (
+	SELECT
+		parts.*,
+		CASE
+			WHEN parents.partstrat = 'r' AND parents.partnatts = 1
+			THEN btrim(substring(parts.bound FROM 'TO \((.+)\)$'), '''')
+		END AS upper,
+		parents.keytype
+	FROM parts
+	JOIN parents ON parents.oid = parts.parent
+), ranked AS (
+	SELECT
+		bounds.*,
+		CASE
+			WHEN upper IS NULL OR upper = 'MAXVALUE' THEN NULL
+			WHEN keytype IN ('date'::regtype, 'timestamp'::regtype, 'timestamptz'::regtype)
+			THEN extract(epoch FROM upper::timestamptz)::numeric
+			WHEN keytype IN ('int2'::regtype, 'int4'::regtype, 'int8'::regtype, 'numeric'::regtype)
+			THEN upper::numeric
+		END AS upper_value,
+		CASE
+			WHEN bound = 'DEFAULT' AND reltuples >= 0
+			THEN reltuples::bigint
+		END AS default_rows
+	FROM bounds
+)
+SELECT COALESCE(json_object_agg(name, row_to_json(T)), '{}')
+  FROM (
+	SELECT
+		parents.name,
+		CASE parents.partstrat WHEN 'r' THEN 'range' WHEN 'l' THEN 'list' WHEN 'h' THEN 'hash' END AS strategy,
+		count(ranked.oid) AS partition_count,
+		COALESCE(bool_or(ranked.bound = 'DEFAULT'), false)::int AS has_default,
+		max(ranked.default_rows) AS default_rows,
+		(array_agg(ranked.upper ORDER BY ranked.upper_value DESC NULLS LAST))[1] AS newest_upper_bound,
+		max(ranked.upper_value) AS newest_upper_bound_value,
+		COALESCE(json_object_agg(ranked.name, pg_catalog.pg_total_relation_size(ranked.oid))
+			FILTER (WHERE ranked.oid IS NOT NULL), '{}') AS partitions
+	FROM parents
+	LEFT JOIN ranked ON ranked.parent = parents.oid
+	GROUP BY parents.oid, parents.name, parents.partstrat
+) T;
@@ -0,0 +1,14 @@
+SELECT
+    pg_catalog.pg_has_role('pg_monitor', 'MEMBER'),
+    (SELECT rolsuper FROM pg_catalog.pg_roles WHERE rolname = current_user),
+    pg_catalog.has_function_privilege(pg_catalog.to_regprocedure('pg_catalog.pg_ls_waldir()'), 'EXECUTE'),
+    pg_catalog.has_function_privilege(pg_catalog.to_regprocedure('pg_catalog.pg_ls_tmpdir()'), 'EXECUTE'),
+    EXISTS (SELECT 1 FROM pg_catalog.pg_extension WHERE extname = 'pg_stat_statements'),
+    EXISTS (SELECT 1 FROM pg_catalog.pg_available_extensions WHERE name = 'pg_stat_statements'),
+    (SELECT 'pg_stat_statements' = ANY (pg_catalog.string_to_array(pg_catalog.replace(setting, ' ', ''), ','))
+       FROM pg_catalog.pg_settings WHERE name = 'shared_preload_libraries'),
+    EXISTS (SELECT 1 FROM pg_catalog.pg_extension WHERE extname = 'pg_buffercache'),
+    EXISTS (SELECT 1 FROM pg_catalog.pg_available_extensions WHERE name = 'pg_buffercache'),
+    pg_catalog.current_setting('track_io_timing')::bool,
+    (SELECT setting::integer FROM pg_catalog.pg_settings WHERE name = 'track_activity_query_size'),
+    pg_catalog.current_setting('track_functions');
@@ -0,0 +1 @@
+SELECT pg_is_in_recovery();
@@ -0,0 +1,41 @@
+WITH tmp AS (
+	SELECT
+		t.spcname,
+		f.name,
+		f.size
+	FROM pg_catalog.pg_tablespace t
+	LEFT JOIN LATERAL pg_catalog.pg_ls_tmpdir(t.oid) f ON true
+	WHERE t.spcname <> 'pg_global'
+)
+SELECT
+	(SELECT json_object_agg(spcname, row_to_json(S))
+	   FROM (
+			SELECT spcname, count(name) AS files, COALESCE(sum(size), 0) AS bytes
+			FROM tmp
+			GROUP BY spcname
+		) S),
+	(SELECT COALESCE(json_agg(B), '[]')
+	   FROM (
+			SELECT
+				f.pid,
+				f.files,
+				f.bytes,
+				a.datname,
+				a.usename,
+				a.application_name,
+				a.state,
+				extract(epoch FROM a.query_start)::bigint AS query_start
+			FROM (
+				SELECT
+					substring(name FROM '^pgsql_tmp(\d+)')::int AS pid,
+					count(*) AS files,
+					sum(size) AS bytes
+				FROM tmp
+				WHERE name ~ '^pgsql_tmp\d+'
+				GROUP BY 1
+				ORDER BY 3 DESC
+				LIMIT $1
+			) f
+			LEFT JOIN pg_catalog.pg_stat_activity a ON a.pid = f.pid
+			ORDER BY f.bytes DESC
+		) B);
@@ -0,0 +1,151 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_scanSQL(t *testing.T) {
+	text := `SELECT "My ""Col""", E'it\'s;', 'a''b', $q$ ; drop $q$, $1::int, 1.5e-3 -- comment;
+	/* block /* nested */ ; */ FROM s.t;`
+
+	want := []sqlToken{
+		{tokenIdent, "select"}, {tokenQuotedIdent, `My "Col"`}, {tokenPunct, ","},
+		{tokenString, `E'it\'s;'`}, {tokenPunct, ","}, {tokenString, `'a''b'`}, {tokenPunct, ","},
+		{tokenString, "$q$ ; drop $q$"}, {tokenPunct, ","}, {tokenParam, "$1"}, {tokenPunct, "::"},
+		{tokenIdent, "int"}, {tokenPunct, ","}, {tokenNumber, "1.5e-3"}, {tokenIdent, "from"},
+		{tokenIdent, "s"}, {tokenPunct, "."}, {tokenIdent, "t"}, {tokenPunct, ";"},
+	}
+
+	got, err := scanSQL(text)
+	if err != nil {
+		t.Fatalf("scanSQL() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("scanSQL() = %v, want %v", got, want)
+	}
+
+	for _, text := range []string{"SELECT 'a", `SELECT "a`, "SELECT $$a", "SELECT /* a", "SELECT $a"} {
+		if _, err := scanSQL(text); err == nil {
+			t.Errorf("scanSQL(%q) error = nil, want an error", text)
+		}
+	}
+}
+
+func Test_checkSingleStatement(t *testing.T) {
+	tests := []struct {
+		text    string
+		wantErr bool
+	}{
+		{"SELECT 1", false},
+		{"SELECT 1;", false},
+		{"SELECT 1;; -- done", false},
+		{"SELECT ';' AS a, $$;$$ AS b", false},
+		{"SELECT 1; SELECT 2", true},
+		{"SELECT 1; DELETE FROM t;", true},
+	}
+	for _, tt := range tests {
+		tokens, err := scanSQL(tt.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = checkSingleStatement(tokens); (err != nil) != tt.wantErr {
+			t.Errorf("checkSingleStatement(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+		}
+	}
+}
+
+func Test_allowList_check(t *testing.T) {
+	a := parseAllowList(" public, monitoring ,count, pg_catalog.now,Extract_Me,")
+
+	tests := []struct {
+		name    string
+		text    string
+		wantErr string
+	}{
+		{"allowed", "SELECT count(*), now() FROM pg_stat_activity", ""},
+		{"qualified allowed", "SELECT monitoring.f(x) FROM public.t JOIN monitoring.u ON true", ""},
+		{"denied function", "SELECT pg_terminate_backend(pid) FROM pg_stat_activity",
+			"function pg_terminate_backend is not in the allow-list"},
+		{"denied qualified function", "SELECT other.f()", "function other.f is not in the allow-list"},
+		{"denied relation", "SELECT 1 FROM public.t, secret.passwords p",
+			"schema secret of relation secret.passwords is not in the allow-list"},
+		{"denied join", "SELECT 1 FROM t LEFT JOIN LATERAL secret.u ON true", "schema secret"},
+		{"column references", "SELECT a.datname, a.x FROM pg_stat_database a WHERE a.datname IS DISTINCT FROM b.c",
+			""},
+		{"keywords", "SELECT CAST(x AS numeric(10, 2)), coalesce(a, b), x IN (1), EXISTS (SELECT 1), " +
+			"extract(epoch FROM s.ts), substring(s.v FROM 1), trim(both FROM s.v) FROM t", ""},
+		{"casts", "SELECT x::numeric(10,2), y::regclass FROM t", ""},
+		{"alias column list", "SELECT 1 FROM (VALUES (1)) AS v(n)", ""},
+		{"subquery", "SELECT 1 FROM (SELECT 1 FROM secret.t) s", "schema secret"},
+		{"table statement", "TABLE secret.t", "schema secret"},
+		{"table subquery", "SELECT * FROM public.t WHERE x IN (TABLE ONLY secret.t)", "schema secret"},
+		{"allowed table statement", "TABLE public.t", ""},
+		{"insert target", "INSERT INTO secret.t (a, b) VALUES (1, 2)", "schema secret"},
+		{"insert select target", "INSERT INTO secret.t SELECT * FROM public.t", "schema secret"},
+		{"update target", "UPDATE secret.t SET a = 1", "schema secret"},
+		{"update only target", "UPDATE ONLY secret.t SET a = 1", "schema secret"},
+		{"copy target", "COPY secret.t (a) TO STDOUT", "schema secret"},
+		{"delete using", "DELETE FROM public.t USING secret.u WHERE t.a = u.a", "schema secret"},
+		{"allowed insert", "INSERT INTO t (a, b) VALUES (1, 2) ON CONFLICT (a) DO UPDATE SET b = 2", ""},
+		{"allowed update", "UPDATE public.t SET a = 1 FROM u WHERE t.b = u.b", ""},
+		{"join using", "SELECT 1 FROM t JOIN u USING (a, b)", ""},
+		{"select for update", "SELECT 1 FROM t FOR UPDATE OF t SKIP LOCKED", ""},
+		{"strings and comments", "SELECT 'pg_sleep(1)' /* pg_sleep(1) */ FROM t -- pg_sleep(1)", ""},
+		{"quoted function", `SELECT "pg_sleep"(1)`, "function pg_sleep is not in the allow-list"},
+		{"mixed case entry", "SELECT extract_me(1)", ""},
+		{"window", "SELECT count(*) OVER (PARTITION BY a ORDER BY b) FROM t", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := scanSQL(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = a.check(tokens)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("allowList.check() error = %v, want nil", err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("allowList.check() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_parseAllowList(t *testing.T) {
+	if a := parseAllowList(" , "); a != nil {
+		t.Errorf("parseAllowList() = %v, want nil for an empty list", a)
+	}
+
+	a := parseAllowList("public,Monitoring,pg_catalog.now")
+	if got := a.searchPath(); got != `"monitoring", "public"` {
+		t.Errorf("allowList.searchPath() = %s, want the schemas", got)
+	}
+}
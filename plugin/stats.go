@@ -0,0 +1,146 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"git.zabbix.com/ap/plugin-support/zbxerr"
+)
+
+const keyPluginStats = "pgsql.plugin.stats"
+
+var _ = registerMetric(keyPluginStats, metricSpec{
+	description:   "Returns JSON with statistics of the plugin's connections and key calls.",
+	pluginHandler: (*Plugin).pluginStats,
+})
+
+// latencySamples is the number of the latest calls of a key used to compute latency percentiles.
+const latencySamples = 1024
+
+// keyStats holds statistics of calls of a single key.
+type keyStats struct {
+	calls     int64
+	errors    int64
+	latencies []time.Duration
+	next      int
+}
+
+// callStats holds statistics of calls of all keys.
+type callStats struct {
+	mutex sync.Mutex
+	keys  map[string]*keyStats
+}
+
+func newCallStats() *callStats {
+	return &callStats{keys: make(map[string]*keyStats)}
+}
+
+// record registers a call of a key which took a given time and failed if err is not nil.
+func (s *callStats) record(key string, latency time.Duration, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats, ok := s.keys[key]
+	if !ok {
+		stats = &keyStats{latencies: make([]time.Duration, 0, latencySamples)}
+		s.keys[key] = stats
+	}
+
+	stats.calls++
+
+	if err != nil {
+		stats.errors++
+	}
+
+	if len(stats.latencies) < latencySamples {
+		stats.latencies = append(stats.latencies, latency)
+	} else {
+		stats.latencies[stats.next] = latency
+	}
+
+	stats.next = (stats.next + 1) % latencySamples
+}
+
+// keyStatsJSON is a JSON representation of statistics of a key, latencies are in milliseconds.
+type keyStatsJSON struct {
+	Calls  int64   `json:"calls"`
+	Errors int64   `json:"errors"`
+	P50    float64 `json:"latency_p50"`
+	P90    float64 `json:"latency_p90"`
+	P99    float64 `json:"latency_p99"`
+	Max    float64 `json:"latency_max"`
+}
+
+// snapshot returns statistics of each key called so far.
+func (s *callStats) snapshot() map[string]keyStatsJSON {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	res := make(map[string]keyStatsJSON, len(s.keys))
+
+	for key, stats := range s.keys {
+		latencies := make([]time.Duration, len(stats.latencies))
+		copy(latencies, stats.latencies)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+		res[key] = keyStatsJSON{
+			Calls:  stats.calls,
+			Errors: stats.errors,
+			P50:    percentile(latencies, 50),
+			P90:    percentile(latencies, 90),
+			P99:    percentile(latencies, 99),
+			Max:    percentile(latencies, 100),
+		}
+	}
+
+	return res
+}
+
+// percentile returns a given percentile of sorted latencies in milliseconds using the nearest-rank method.
+func percentile(sorted []time.Duration, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return float64(sorted[rank-1].Microseconds()) / 1000
+}
+
+// pluginStats returns JSON with statistics of the plugin itself: its connections and calls of each key.
+func (p *Plugin) pluginStats() (interface{}, error) {
+	jsonRes, err := json.Marshal(struct {
+		connManagerStats
+		Keys map[string]keyStatsJSON `json:"keys"`
+	}{
+		connManagerStats: p.connMgr.Stats(),
+		Keys:             p.stats.snapshot(),
+	})
+	if err != nil {
+		return nil, zbxerr.ErrorCannotMarshalJSON.Wrap(err)
+	}
+
+	return string(jsonRes), nil
+}
@@ -0,0 +1,371 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCert creates a self-signed CA certificate named after its serial number and returns it with its private key.
+func newTestCert(t *testing.T, serial int64) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "postgres CA " + big.NewInt(serial).String()},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func Test_newTLSOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   map[string]string
+		wantMode string
+		wantErr  bool
+	}{
+		{"unencrypted", map[string]string{}, sslModeDisable, false},
+		{"legacy name", map[string]string{"TLSConnect": "required"}, sslModeRequire, false},
+		{"libpq name", map[string]string{"TLSConnect": "prefer"}, sslModePrefer, false},
+		{
+			"system CA",
+			map[string]string{"TLSConnect": "verify-full", "TLSCAFile": systemCAFile},
+			sslModeVerifyFull,
+			false,
+		},
+		{"unsupported mode", map[string]string{"TLSConnect": "verify"}, "", true},
+		{"unsupported version", map[string]string{"TLSMinVersion": "SSLv3"}, "", true},
+		{"verification without CA", map[string]string{"TLSConnect": "verify_ca"}, "", true},
+		{"certificate without key", map[string]string{"TLSCertFile": "/etc/ssl/cert.pem"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newTLSOptions(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newTLSOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got.mode != tt.wantMode {
+				t.Errorf("newTLSOptions() mode = %v, want %v", got.mode, tt.wantMode)
+			}
+		})
+	}
+}
+
+func Test_tlsOptions_configs(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		host string
+		want []bool
+	}{
+		{"disable", sslModeDisable, "pg1", []bool{false}},
+		{"allow", sslModeAllow, "pg1", []bool{false, true}},
+		{"prefer", sslModePrefer, "pg1", []bool{true, false}},
+		{"require", sslModeRequire, "pg1", []bool{true}},
+		{"socket", sslModeRequire, "/var/run/postgresql", []bool{false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs, err := tlsOptions{mode: tt.mode}.configs(tt.host)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(configs) != len(tt.want) {
+				t.Fatalf("tlsOptions.configs() returned %d configurations, want %d", len(configs), len(tt.want))
+			}
+
+			for i, encrypted := range tt.want {
+				if (configs[i] != nil) != encrypted {
+					t.Errorf("tlsOptions.configs()[%d] encrypted = %v, want %v", i, configs[i] != nil, encrypted)
+				}
+			}
+		})
+	}
+}
+
+func Test_tlsOptions_config(t *testing.T) {
+	cert, _ := newTestCert(t, 1)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		opts           tlsOptions
+		wantSkipVerify bool
+		wantVerifyFunc bool
+		wantServerName string
+	}{
+		{"require", tlsOptions{mode: sslModeRequire}, true, false, "pg1"},
+		{"require with CA", tlsOptions{mode: sslModeRequire, caFile: caFile}, true, true, "pg1"},
+		{"verify-ca", tlsOptions{mode: sslModeVerifyCA, caFile: caFile}, true, true, "pg1"},
+		{"verify-full", tlsOptions{mode: sslModeVerifyFull, caFile: caFile}, false, false, "pg1"},
+		{
+			"server name",
+			tlsOptions{mode: sslModeVerifyFull, caFile: caFile, serverName: "db.example.com"},
+			false, false, "db.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := tt.opts.config("pg1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if config.InsecureSkipVerify != tt.wantSkipVerify || (config.VerifyPeerCertificate != nil) != tt.wantVerifyFunc ||
+				config.ServerName != tt.wantServerName {
+				t.Errorf("tlsOptions.config() = skip verify %v, verify func %v, server name %q, want %v, %v, %q",
+					config.InsecureSkipVerify, config.VerifyPeerCertificate != nil, config.ServerName,
+					tt.wantSkipVerify, tt.wantVerifyFunc, tt.wantServerName)
+			}
+		})
+	}
+}
+
+// newTestLeaf creates a certificate issued by a given CA.
+func newTestLeaf(t *testing.T, serial int64, ca *x509.Certificate, caKey *ecdsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "pg1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+// newTestCRL creates a CRL of a given issuer revoking given serial numbers, signed by a given key.
+func newTestCRL(t *testing.T, issuer *x509.Certificate, key *ecdsa.PrivateKey, nextUpdate time.Time,
+	serials ...int64) *x509.RevocationList {
+	t.Helper()
+
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: nextUpdate,
+	}
+
+	for _, serial := range serials {
+		template.RevokedCertificates = append(template.RevokedCertificates,
+			pkix.RevokedCertificate{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, issuer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return crl
+}
+
+func Test_verifyPeerCertificate(t *testing.T) {
+	ca, caKey := newTestCert(t, 1)
+	otherCA, otherKey := newTestCert(t, 2)
+	_, forgedKey := newTestCert(t, 3)
+
+	leaf := newTestLeaf(t, 10, ca, caKey)
+	untrusted := newTestLeaf(t, 10, otherCA, otherKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	tomorrow := time.Now().Add(24 * time.Hour)
+
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		crls    []*x509.RevocationList
+		wantErr bool
+	}{
+		{"trusted", leaf, nil, false},
+		{"untrusted", untrusted, nil, true},
+		{"not revoked", leaf, []*x509.RevocationList{newTestCRL(t, ca, caKey, tomorrow, 11)}, false},
+		{"revoked", leaf, []*x509.RevocationList{newTestCRL(t, ca, caKey, tomorrow, 10)}, true},
+		{
+			"revoked by another CA",
+			leaf,
+			[]*x509.RevocationList{newTestCRL(t, otherCA, otherKey, tomorrow, 10)},
+			false,
+		},
+		{"forged CRL", leaf, []*x509.RevocationList{newTestCRL(t, ca, forgedKey, tomorrow, 11)}, true},
+		{
+			"expired CRL",
+			leaf,
+			[]*x509.RevocationList{newTestCRL(t, ca, caKey, time.Now().Add(-time.Minute), 11)},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyPeerCertificate(roots, tt.crls)([][]byte{tt.cert.Raw}, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyPeerCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_readCRL(t *testing.T) {
+	ca, caKey := newTestCert(t, 1)
+	crl := newTestCRL(t, ca, caKey, time.Now().Add(time.Hour), 10)
+
+	dir := t.TempDir()
+	pemFile := filepath.Join(dir, "root.crl.pem")
+	derFile := filepath.Join(dir, "root.crl")
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl.Raw})
+	if err := os.WriteFile(pemFile, append(data, data...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(derFile, crl.Raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    int
+		wantErr bool
+	}{
+		{"not set", "", 0, false},
+		{"PEM", pemFile, 2, false},
+		{"DER", derFile, 1, false},
+		{"missing", filepath.Join(dir, "missing.crl"), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readCRL(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readCRL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if len(got) != tt.want {
+				t.Errorf("readCRL() returned %d CRLs, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func Test_loadKeyPair(t *testing.T) {
+	cert, key := newTestCert(t, 1)
+	dir := t.TempDir()
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", der, []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{"correct password", "secret", false},
+		{"wrong password", "wrong", true},
+		{"no password", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadKeyPair(certFile, keyFile, tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadKeyPair() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && len(got.Certificate) != 1 {
+				t.Errorf("loadKeyPair() returned %d certificates, want 1", len(got.Certificate))
+			}
+		})
+	}
+}
@@ -0,0 +1,466 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.zabbix.com/ap/plugin-support/uri"
+	"github.com/omeid/go-yarn"
+)
+
+// fakeDialer emulates connecting to PostgreSQL instances. Connecting to a host listed in blocked
+// hangs until release is closed, the same way as dialing an unreachable host hangs until the connect timeout.
+type fakeDialer struct {
+	blocked map[string]bool
+	release chan struct{}
+	started chan string
+	calls   int32
+	err     error
+}
+
+func (d *fakeDialer) connect(u uri.URI, _ tlsOptions, opts connOptions) (*PGConn, error) {
+	atomic.AddInt32(&d.calls, 1)
+	d.started <- u.Host()
+
+	if d.blocked[u.Host()] {
+		<-d.release
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	// sql.Open does not establish any connections, so it is safe to use it without a server.
+	client, err := sql.Open("pgx", "host="+u.Host())
+	if err != nil {
+		return nil, err
+	}
+
+	client.SetMaxOpenConns(opts.maxOpenConns)
+
+	return &PGConn{client: client, lastTimeAccess: time.Now(), uri: u, options: opts}, nil
+}
+
+func newTestConnManager(t *testing.T, d *fakeDialer) *ConnManager {
+	t.Helper()
+
+	connMgr := NewConnManager(time.Minute, time.Minute, time.Minute, time.Minute,
+		newQueryStore(yarn.NewFromMap(map[string]string{}), builtinCatalogue, queryPolicy{readOnly: true, numericPrecision: -1}))
+	connMgr.connect = d.connect
+	t.Cleanup(connMgr.Destroy)
+
+	return connMgr
+}
+
+func mustURI(t *testing.T, rawURI string) uri.URI {
+	t.Helper()
+
+	u, err := uri.NewWithCreds(rawURI+"?dbname=postgres", "postgres", "", uriDefaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return *u
+}
+
+func TestConnManager_GetConnection_noHeadOfLineBlocking(t *testing.T) {
+	d := &fakeDialer{
+		blocked: map[string]bool{"192.0.2.1": true},
+		release: make(chan struct{}),
+		started: make(chan string, 2),
+	}
+	connMgr := newTestConnManager(t, d)
+
+	unreachable := mustURI(t, "tcp://192.0.2.1:5432")
+	reachable := mustURI(t, "tcp://127.0.0.1:5432")
+
+	go func() {
+		_, _ = connMgr.GetConnection(unreachable, tlsOptions{}, connOptions{})
+	}()
+
+	if host := <-d.started; host != unreachable.Host() {
+		t.Fatalf("unexpected connection attempt to %s", host)
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := connMgr.GetConnection(reachable, tlsOptions{}, connOptions{})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("ConnManager.GetConnection() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		close(d.release)
+		t.Fatal("ConnManager.GetConnection() for a reachable host is blocked by a pending connection to another host")
+	}
+
+	close(d.release)
+}
+
+func TestConnManager_GetConnection_separatesTLSSettings(t *testing.T) {
+	d := &fakeDialer{started: make(chan string, 3)}
+	connMgr := newTestConnManager(t, d)
+
+	u := mustURI(t, "tcp://127.0.0.1:5432")
+	weak := tlsOptions{mode: sslModeRequire}
+	strict := tlsOptions{mode: sslModeVerifyFull, caFile: "/etc/ssl/root.crt"}
+
+	weakConn, err := connMgr.GetConnection(u, weak, connOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strictConn, err := connMgr.GetConnection(u, strict, connOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strictConn == weakConn {
+		t.Fatal("ConnManager.GetConnection() reused a connection established with different TLS settings")
+	}
+
+	if conn, err := connMgr.GetConnection(u, weak, connOptions{}); err != nil || conn != weakConn {
+		t.Errorf("ConnManager.GetConnection() did not reuse a connection with the same TLS settings, error = %v", err)
+	}
+
+	if calls := atomic.LoadInt32(&d.calls); calls != 2 {
+		t.Errorf("expected 2 connection attempts, got %d", calls)
+	}
+}
+
+func TestConnManager_GetConnection_separatesSessionOptions(t *testing.T) {
+	d := &fakeDialer{started: make(chan string, 3)}
+	connMgr := newTestConnManager(t, d)
+
+	p := Plugin{options: PluginOptions{
+		CallTimeout: 10,
+		Sessions: map[string]Session{
+			"Fast": {LockTimeout: 1, MaxOpenConns: 2},
+			"Slow": {LockTimeout: 5, MaxOpenConns: 4},
+		},
+	}}
+
+	u := mustURI(t, "tcp://127.0.0.1:5432")
+
+	fastConn, err := connMgr.GetConnection(u, tlsOptions{}, p.connOptions("Fast"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slowConn, err := connMgr.GetConnection(u, tlsOptions{}, p.connOptions("Slow"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if slowConn == fastConn {
+		t.Fatal("ConnManager.GetConnection() reused a connection of a session with different options")
+	}
+
+	if got := slowConn.options.lockTimeout; got != 5*time.Second {
+		t.Errorf("ConnManager.GetConnection() lockTimeout = %v, want %v", got, 5*time.Second)
+	}
+
+	if got := slowConn.PoolStats().MaxOpenConnections; got != 4 {
+		t.Errorf("ConnManager.GetConnection() MaxOpenConnections = %d, want 4", got)
+	}
+
+	if conn, err := connMgr.GetConnection(u, tlsOptions{}, p.connOptions("Fast")); err != nil || conn != fastConn {
+		t.Errorf("ConnManager.GetConnection() did not reuse a connection with the same options, error = %v", err)
+	}
+}
+
+func TestConnManager_GetConnection_coalescesConcurrentRequests(t *testing.T) {
+	const callers = 10
+
+	d := &fakeDialer{
+		blocked: map[string]bool{"192.0.2.1": true},
+		release: make(chan struct{}),
+		started: make(chan string, callers),
+	}
+	connMgr := newTestConnManager(t, d)
+
+	u := mustURI(t, "tcp://192.0.2.1:5432")
+
+	var wg sync.WaitGroup
+
+	conns := make([]*PGConn, callers)
+
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			conn, err := connMgr.GetConnection(u, tlsOptions{}, connOptions{})
+			if err != nil {
+				t.Errorf("ConnManager.GetConnection() error = %v", err)
+			}
+
+			conns[i] = conn
+		}(i)
+	}
+
+	<-d.started
+	// Give the rest of callers a chance to join the pending connection attempt.
+	time.Sleep(100 * time.Millisecond)
+	close(d.release)
+	wg.Wait()
+
+	if calls := atomic.LoadInt32(&d.calls); calls != 1 {
+		t.Errorf("expected 1 connection attempt, got %d", calls)
+	}
+
+	for _, conn := range conns {
+		if conn == nil || conn != conns[0] {
+			t.Fatalf("expected all callers to get the same connection")
+		}
+	}
+}
+
+func TestConnManager_GetConnection_backoff(t *testing.T) {
+	d := &fakeDialer{
+		started: make(chan string, 10),
+		err:     errors.New("connection refused"),
+	}
+	connMgr := newTestConnManager(t, d)
+
+	now := time.Now()
+	connMgr.now = func() time.Time { return now }
+
+	u := mustURI(t, "tcp://192.0.2.1:5432")
+
+	tests := []struct {
+		name      string
+		advance   time.Duration
+		dialErr   error
+		wantCalls int32
+		wantErr   bool
+	}{
+		{"first attempt dials", 0, d.err, 1, true},
+		{"circuit is open", 0, d.err, 1, true},
+		{"circuit is open before backoff expires", reconnectBackoffMin - time.Second, d.err, 1, true},
+		{"half-open probe dials after backoff", 2 * time.Second, d.err, 2, true},
+		{"backoff is doubled after a failed probe", reconnectBackoffMin + time.Second, d.err, 2, true},
+		{"second probe dials after doubled backoff", reconnectBackoffMin, d.err, 3, true},
+		{"successful probe closes circuit", 4 * reconnectBackoffMin, nil, 4, false},
+		{"connection is reused", 0, nil, 4, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			d.err = tt.dialErr
+
+			_, err := connMgr.GetConnection(u, tlsOptions{}, connOptions{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConnManager.GetConnection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil && !strings.Contains(err.Error(), tt.dialErr.Error()) {
+				t.Errorf("ConnManager.GetConnection() error = %v, want cached error %v", err, tt.dialErr)
+			}
+
+			if calls := atomic.LoadInt32(&d.calls); calls != tt.wantCalls {
+				t.Errorf("expected %d connection attempts, got %d", tt.wantCalls, calls)
+			}
+		})
+	}
+}
+
+func Test_backoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, reconnectBackoffMin},
+		{2, 2 * reconnectBackoffMin},
+		{3, 4 * reconnectBackoffMin},
+		{100, reconnectBackoffMax},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func Test_connOptions_runtimeParams(t *testing.T) {
+	opts := connOptions{
+		applicationName:  defaultApplicationName,
+		statementTimeout: 10 * time.Second,
+		lockTimeout:      1500 * time.Millisecond,
+	}
+
+	want := map[string]string{
+		"application_name":  defaultApplicationName,
+		"statement_timeout": "10000",
+		"lock_timeout":      "1500",
+	}
+
+	if got := opts.runtimeParams(); !reflect.DeepEqual(got, want) {
+		t.Errorf("connOptions.runtimeParams() = %v, want %v", got, want)
+	}
+}
+
+func TestPlugin_connOptions_lockTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		callTimeout int
+		session     int
+		want        time.Duration
+	}{
+		{"default", 10, 0, defaultLockTimeout},
+		{"short call timeout", 1, 0, time.Second},
+		{"session", 10, 7, 7 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Plugin{options: PluginOptions{
+				CallTimeout: tt.callTimeout,
+				Sessions:    map[string]Session{"s": {LockTimeout: tt.session}},
+			}}
+
+			if got := p.connOptions("s").lockTimeout; got != tt.want {
+				t.Errorf("Plugin.connOptions() lockTimeout = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPGConn_countTimeout(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want int64
+	}{
+		{"no error", expired, nil, 0},
+		{"query error", context.Background(), errors.New("syntax error"), 0},
+		{"canceled", context.Background(), context.Canceled, 0},
+		{"deadline exceeded", context.Background(), context.DeadlineExceeded, 1},
+		{"wrapped deadline exceeded", context.Background(), fmt.Errorf("query: %w", context.DeadlineExceeded), 1},
+		{"error of timed out call", expired, errors.New("conn closed"), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &PGConn{canceledQueries: new(int64)}
+			conn.countTimeout(tt.ctx, tt.err)
+
+			if got := conn.CanceledQueries(); got != tt.want {
+				t.Errorf("PGConn.CanceledQueries() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dsnHosts(t *testing.T) {
+	tests := []struct {
+		name      string
+		addr      string
+		wantHosts []string
+		wantPorts []string
+		wantErr   bool
+	}{
+		{"single host", "tcp://localhost:5432", []string{"localhost"}, []string{"5432"}, false},
+		{
+			"multiple hosts",
+			"tcp://pg1:5432,pg2:5433, postgresql://pg3",
+			[]string{"pg1", "pg2", "pg3"},
+			[]string{"5432", "5433", "5432"},
+			false,
+		},
+		{
+			"socket",
+			"unix:/var/run/postgresql/.s.PGSQL.5432,tcp://pg2:5432",
+			[]string{"/var/run/postgresql", "pg2"},
+			[]string{"5432", "5432"},
+			false,
+		},
+		{"incorrect socket", "tcp://pg1:5432,unix:/var/run/postgresql/socket", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts, ports, err := dsnHosts(mustURI(t, connURI(tt.addr, "postgres", targetSessionAttrsPrimary)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dsnHosts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(hosts, tt.wantHosts) || !reflect.DeepEqual(ports, tt.wantPorts) {
+				t.Errorf("dsnHosts() = %v, %v, want %v, %v", hosts, ports, tt.wantHosts, tt.wantPorts)
+			}
+		})
+	}
+}
+
+func Test_connURI(t *testing.T) {
+	single := mustURI(t, connURI("tcp://pg1:5432", "postgres", targetSessionAttrsAny))
+	if single.GetParam("hosts") != "" || single.GetParam("target_session_attrs") != "" {
+		t.Errorf("connURI() of a single host with any node = %s, want no extra parameters", single.String())
+	}
+
+	primary := mustURI(t, connURI("tcp://pg1:5432,tcp://pg2:5432", "postgres", targetSessionAttrsPrimary))
+	standby := mustURI(t, connURI("tcp://pg1:5432,tcp://pg2:5432", "postgres", targetSessionAttrsStandby))
+
+	if primary == standby {
+		t.Errorf("connURI() must differ for different target_session_attrs")
+	}
+
+	if got := primary.GetParam("hosts"); got != "tcp://pg2:5432" {
+		t.Errorf("connURI() hosts = %q, want %q", got, "tcp://pg2:5432")
+	}
+}
+
+func TestPostgresURIValidator_Validate(t *testing.T) {
+	v := PostgresURIValidator{Defaults: uriDefaults, AllowedSchemes: []string{"tcp", "postgresql", "unix"}}
+
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"single host", "tcp://localhost:5432", false},
+		{"multiple hosts", "tcp://pg1:5432,pg2:5432,unix:/tmp/.s.PGSQL.5432", false},
+		{"empty host", "tcp://pg1:5432,,pg2:5432", true},
+		{"unsupported scheme", "tcp://pg1:5432,https://pg2:5432", true},
+		{"incorrect socket", "tcp://pg1:5432,unix:/tmp/socket", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := v.Validate(&tt.value); (err != nil) != tt.wantErr {
+				t.Errorf("PostgresURIValidator.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"git.zabbix.com/ap/plugin-support/zbxerr"
+	"github.com/jackc/pgconn"
+	"github.com/omeid/go-yarn"
+)
+
+func Test_parseCustomQuery(t *testing.T) {
+	text := `-- Long transactions.
+-- @description: Transactions running longer than a given time
+-- @timeout: 5
+-- @output: rows
+-- @param: age int default=600 min=0
+-- @param: state string values=active|idle
+-- @min_version: 12
+-- @max_version: 16
+-- @extension: pg_stat_statements
+-- @readonly: true
+
+SELECT count(*) FROM pg_stat_activity
+-- @timeout: 1
+WHERE state = $2 AND now() - xact_start > $1 * interval '1 second';
+`
+
+	q, err := parseCustomQuery("long_tx", text)
+	if err != nil {
+		t.Fatalf("parseCustomQuery() error = %v", err)
+	}
+
+	min := 0.0
+	def := "600"
+	readOnly := true
+	want := &customQuery{
+		name:        "long_tx",
+		sql:         strings.TrimRight(strings.TrimSpace(text), ";"),
+		description: "Transactions running longer than a given time",
+		timeout:     5 * time.Second,
+		output:      outputRows,
+		params: []queryParam{
+			{Name: "age", Type: paramTypeInt, Default: &def, Min: &min},
+			{Name: "state", Type: paramTypeString, Values: []string{"active", "idle"}, Required: true},
+		},
+		minVersion: 120000,
+		maxVersion: 169999,
+		extension:  "pg_stat_statements",
+		readOnly:   &readOnly,
+	}
+
+	if !reflect.DeepEqual(q, want) {
+		t.Errorf("parseCustomQuery() = %+v, want %+v", q, want)
+	}
+}
+
+func Test_parseCustomQuery_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr string
+	}{
+		{"empty", "-- @timeout: 5\n;", "query is empty"},
+		{"no colon", "-- @timeout 5\nSELECT 1", "invalid header line"},
+		{"unknown directive", "-- @retries: 5\nSELECT 1", "invalid @retries: unknown directive"},
+		{"duplicate", "-- @timeout: 5\n-- @timeout: 6\nSELECT 1", "duplicate @timeout"},
+		{"bad timeout", "-- @timeout: 0\nSELECT 1", "invalid @timeout"},
+		{"bad output", "-- @output: xml\nSELECT 1", "unknown output mode"},
+		{"bad version", "-- @min_version: 9\nSELECT 1", "PostgreSQL 9 is not supported"},
+		{"version range", "-- @min_version: 14\n-- @max_version: 13\nSELECT 1", "greater than"},
+		{"bad readonly", "-- @readonly: maybe\nSELECT 1", "invalid @readonly"},
+		{"multiple statements", "SELECT 1; DELETE FROM t", "multiple statements"},
+		{"unterminated string", "SELECT 'a", "unterminated string"},
+		{"bad param type", "-- @param: age duration\nSELECT 1", "unknown type"},
+		{"bad param name", "-- @param: 1age int\nSELECT 1", "invalid parameter name"},
+		{"param twice", "-- @param: age int\n-- @param: age int\nSELECT 1", "declared twice"},
+		{"min of string", "-- @param: state string min=1\nSELECT 1", "only for numeric"},
+		{"bad default", "-- @param: age int default=old\nSELECT 1", "invalid default"},
+		{"default not in values", "-- @param: s string default=x values=a|b\nSELECT 1", "invalid default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseCustomQuery("test", tt.text)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseCustomQuery() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_customQuery_bindArgs(t *testing.T) {
+	q, err := parseCustomQuery("test", `-- @param: age int default=600 min=0 max=86400
+-- @param: ratio float default=0.5
+-- @param: all bool default=false
+-- @param: state string values=active|idle
+SELECT 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		q       *customQuery
+		args    []string
+		want    []interface{}
+		wantErr string
+	}{
+		{"no params", &customQuery{name: "raw"}, []string{"a", ""}, []interface{}{"a", ""}, ""},
+		{"defaults", q, []string{"", "", "", "idle"}, []interface{}{"600", "0.5", "false", "idle"}, ""},
+		{"canonical", q, []string{"+60", "1e-1", "1", "active"}, []interface{}{"60", "0.1", "true", "active"}, ""},
+		{"missing required", q, []string{"60"}, nil, "parameter state of query test is required"},
+		{"too many", q, []string{"1", "2", "true", "idle", "x"}, nil, "takes 4 arguments, got 5"},
+		{"not int", q, []string{"1.5", "", "", "idle"}, nil, "parameter age must be an integer"},
+		{"below min", q, []string{"-1", "", "", "idle"}, nil, "parameter age must be at least 0"},
+		{"above max", q, []string{"90000", "", "", "idle"}, nil, "parameter age must be at most 86400"},
+		{"not bool", q, []string{"", "", "yes", "idle"}, nil, "parameter all must be a boolean"},
+		{"not in values", q, []string{"", "", "", "waiting"}, nil, "parameter state must be one of active, idle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.q.bindArgs(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("customQuery.bindArgs() error = %v, want %q", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("customQuery.bindArgs() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_queryStore_listJSON(t *testing.T) {
+	store := newQueryStore(yarn.NewFromMap(map[string]string{
+		"b.sql":   "-- @description: Second\n-- @min_version: 13\n-- @param: n int\nSELECT $1::int",
+		"a.sql":   "SELECT 1",
+		"bad.sql": "-- @output: xml\nSELECT 1",
+	}), builtinCatalogue, queryPolicy{})
+
+	got, err := store.listJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `[{"name":"a","description":"","timeout":0,"output":"rows","params":[],"min_version":0,` +
+		`"max_version":0,"extension":"","readonly":false},{"name":"b","description":"Second","timeout":0,` +
+		`"output":"rows","params":[{"name":"n","type":"int","required":true}],"min_version":13,"max_version":0,` +
+		`"extension":"","readonly":false}]`
+
+	if got != want {
+		t.Errorf("queryStore.listJSON() = %s, want %s", got, want)
+	}
+}
+
+func Test_formatOutput(t *testing.T) {
+	columns := []string{"datname", "size", "stats reset"}
+	table := [][]interface{}{
+		{"postgres", int64(8000), nil},
+		{"zabbix", json.Number("1.5"), true},
+	}
+
+	tests := []struct {
+		name    string
+		mode    string
+		columns []string
+		table   [][]interface{}
+		want    string
+		wantErr string
+	}{
+		{"rows", outputRows, columns, table,
+			`[{"datname":"postgres","size":8000,"stats reset":null},{"datname":"zabbix","size":1.5,"stats reset":true}]`,
+			""},
+		{"rows empty", outputRows, columns, nil, `[]`, ""},
+		{"value string", outputValue, columns, table, `postgres`, ""},
+		{"value number", outputValue, columns[1:], [][]interface{}{{json.Number("1.5")}}, `1.5`, ""},
+		{"value json", outputValue, columns[:1], [][]interface{}{{json.RawMessage(`{"a":1}`)}}, `{"a":1}`, ""},
+		{"value null", outputValue, columns[2:], [][]interface{}{{nil}}, "", "query returned no value"},
+		{"value no rows", outputValue, columns, nil, "", "query returned no value"},
+		{"row", outputRow, columns, table, `{"datname":"postgres","size":8000,"stats reset":null}`, ""},
+		{"row no rows", outputRow, columns, nil, "", "query returned no rows"},
+		{"map", outputMap, columns, table,
+			`{"postgres":{"size":8000,"stats reset":null},"zabbix":{"size":1.5,"stats reset":true}}`, ""},
+		{"map null key", outputMap, columns[:2], [][]interface{}{{nil, int64(1)}, {int64(5), int64(2)}},
+			`{"5":{"size":2},"null":{"size":1}}`, ""},
+		{"map duplicate", outputMap, columns, append(table, table[0]), "", `duplicate value "postgres"`},
+		{"lld", outputLLD, columns, table,
+			`[{"{#DATNAME}":"postgres","{#SIZE}":"8000","{#STATS_RESET}":""},` +
+				`{"{#DATNAME}":"zabbix","{#SIZE}":"1.5","{#STATS_RESET}":"true"}]`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatOutput(tt.mode, tt.columns, tt.table)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("formatOutput() error = %v, want %q", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil || got != tt.want {
+				t.Errorf("formatOutput() = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkOutput(t *testing.T) {
+	for _, mode := range []string{outputRows, outputValue, outputRow, outputMap, outputLLD} {
+		if err := checkOutput(mode); err != nil {
+			t.Errorf("checkOutput(%s) error = %v", mode, err)
+		}
+	}
+
+	if err := checkOutput("xml"); err == nil {
+		t.Errorf("checkOutput(xml) error = nil, want an error")
+	}
+}
+
+func Test_queryPolicy_apply(t *testing.T) {
+	policy := queryPolicy{readOnly: true, allow: parseAllowList("public,count")}
+
+	q, err := parseCustomQuery("test", "SELECT count(*) FROM public.t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = policy.apply(q); err != nil {
+		t.Fatalf("queryPolicy.apply() error = %v", err)
+	}
+
+	if !q.isReadOnly() || q.searchPath == nil || *q.searchPath != `"count", "public"` {
+		t.Errorf("queryPolicy.apply() did not set the defaults: readOnly %v, searchPath %v", q.isReadOnly(), q.searchPath)
+	}
+
+	q, err = parseCustomQuery("test", "-- @readonly: false\nSELECT pg_sleep(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = (queryPolicy{readOnly: true}).apply(q); err != nil || q.isReadOnly() {
+		t.Errorf("queryPolicy.apply() = %v, readOnly %v, want the declared value kept", err, q.isReadOnly())
+	}
+
+	if err = policy.apply(q); err == nil || !strings.Contains(err.Error(), "pg_sleep") {
+		t.Errorf("queryPolicy.apply() error = %v, want the function rejected", err)
+	}
+}
+
+func Test_customQueryError(t *testing.T) {
+	readOnly := true
+	q := &customQuery{name: "test", readOnly: &readOnly, timeout: 2 * time.Second}
+	noTimeout := &customQuery{name: "test", readOnly: &readOnly}
+
+	running := context.Background()
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	statementTimeout := &pgconn.PgError{Code: sqlStateQueryCanceled, Message: "canceling statement due to statement timeout"}
+
+	tests := []struct {
+		name     string
+		q        *customQuery
+		callCtx  context.Context
+		err      error
+		want     string
+		wantKind error
+		wantPg   bool
+	}{
+		{"read-only", q, running,
+			&pgconn.PgError{Code: sqlStateReadOnly, Message: "cannot execute DELETE in a read-only transaction"},
+			"query test: cannot execute DELETE in a read-only transaction; custom queries run in a read-only transaction",
+			errorReadOnlyViolation, false},
+		{"statement timeout", q, running, statementTimeout, "query test did not finish within its timeout of 2s",
+			errorStatementTimeout, false},
+		{"statement timeout of call", noTimeout, running, statementTimeout,
+			"query test did not finish within CallTimeout of 1.5s", zbxerr.ErrorCannotFetchData, false},
+		{"query deadline", q, running, context.DeadlineExceeded, "query test did not finish within its timeout of 2s",
+			errorStatementTimeout, false},
+		{"call deadline", q, expired, context.DeadlineExceeded, "query test did not finish within CallTimeout of 1.5s",
+			zbxerr.ErrorCannotFetchData, false},
+		{"other", q, running, &pgconn.PgError{Code: sqlStatePrivilege, Message: "permission denied"},
+			"permission denied", zbxerr.ErrorCannotFetchData, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := customQueryError(tt.callCtx, tt.q, 1500*time.Millisecond, tt.err)
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("customQueryError() = %v, want %q", err, tt.want)
+			}
+
+			if !strings.HasPrefix(err.Error(), tt.wantKind.Error()) {
+				t.Errorf("customQueryError() = %v, want %v", err, tt.wantKind)
+			}
+
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) != tt.wantPg {
+				t.Errorf("customQueryError() keeps the server error = %v, want %v", !tt.wantPg, tt.wantPg)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_resultEncoder_value(t *testing.T) {
+	e := resultEncoder{numericPrecision: -1}
+
+	tests := []struct {
+		name     string
+		typeName string
+		value    interface{}
+		want     interface{}
+	}{
+		{"null", "NUMERIC", nil, nil},
+		{"int", "INT8", int64(42), int64(42)},
+		{"numeric", "NUMERIC", "12345678901234567890.50", json.Number("12345678901234567890.50")},
+		{"numeric NaN", "NUMERIC", "NaN", "NaN"},
+		{"float", "FLOAT8", 1.5, 1.5},
+		{"float infinity", "FLOAT8", "Infinity", "+Inf"},
+		{"bool", "BOOL", true, true},
+		{"timestamptz", "TIMESTAMPTZ", time.Unix(1700000000, 0), json.Number("1700000000")},
+		{"timestamptz with microseconds", "TIMESTAMPTZ", time.Unix(1700000000, 123456000), json.Number("1700000000.123456")},
+		{"timestamp before epoch", "TIMESTAMP", time.Unix(-2, 500000000), json.Number("-1.5")},
+		{"interval", "INTERVAL", "1 day 02:00:00.5", 93600.5},
+		{"interval with months", "INTERVAL", "1 year 1 mon", 31557600.0 + 2592000.0},
+		{"jsonb", "JSONB", []byte(`{"a": 1}`), json.RawMessage(`{"a": 1}`)},
+		{"text", "TEXT", "1.5", "1.5"},
+		{"int array", "_INT4", "{1,NULL,3}", []interface{}{int64(1), nil, int64(3)}},
+		{"text array", "_TEXT", `{a,"NULL"}`, []interface{}{"a", "NULL"}},
+		{
+			"multidimensional array",
+			"_INT4",
+			"{{1,2},{3,4}}",
+			[]interface{}{[]interface{}{int64(1), int64(2)}, []interface{}{int64(3), int64(4)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := e.value(tt.typeName, tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resultEncoder.value() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resultEncoder_normalizeJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		precision int
+		in        string
+		want      string
+	}{
+		{"no rounding", -1, `{"b": 1.23456, "a": 1}`, `{"b": 1.23456, "a": 1}`},
+		{"rounding", 2, `{"b":1.23456,"a":[1,2.5,10.001],"c":"1.23456"}`, `{"b":1.23,"a":[1,2.5,10],"c":"1.23456"}`},
+		{"formatting kept", 2, "{\n  \"z\": 1.5e-3,\n  \"a\": -7.777\n}", "{\n  \"z\": 0,\n  \"a\": -7.78\n}"},
+		{"escaped strings", 1, `{"a\"1.55":"\\","b":[1.55]}`, `{"a\"1.55":"\\","b":[1.6]}`},
+		{"integer precision", 0, `[0.4,-0.4,7.5]`, `[0,0,8]`},
+		{"not a document", 2, `1.23456`, `1.23456`},
+		{"invalid document", 2, `{"a":`, `{"a":`},
+		{"HTML", 2, `{"q":"a<b"}`, `{"q":"a<b"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (resultEncoder{numericPrecision: tt.precision}).normalizeJSON(tt.in); got != tt.want {
+				t.Errorf("resultEncoder.normalizeJSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resultEncoder_encodeValueRounding(t *testing.T) {
+	e := resultEncoder{numericPrecision: 1}
+
+	tests := []struct {
+		name     string
+		typeName string
+		value    interface{}
+		want     interface{}
+	}{
+		{"numeric", "NUMERIC", "1.25", json.Number("1.3")},
+		{"float", "FLOAT8", 1.25, json.Number("1.3")},
+		{"text", "TEXT", "1.25", "1.25"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := e.value(tt.typeName, tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resultEncoder.value() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -25,30 +25,21 @@ import (
 	"github.com/jackc/pgx/v4"
 )
 
+const keyBgwriter = "pgsql.bgwriter"
+
+var _ = registerMetric(keyBgwriter, metricSpec{
+	description: "Returns JSON for sum of each type of bgwriter statistic.",
+	params:      commonParams,
+	handler:     bgwriterHandler,
+})
+
 // bgwriterHandler executes select  with statistics from pg_stat_bgwriter
 // and returns JSON if all is OK or nil otherwise.
 func bgwriterHandler(ctx context.Context, conn PostgresClient,
 	_ string, _ map[string]string, _ ...string) (interface{}, error) {
 	var bgwriterJSON string
 
-	query := `
-  SELECT row_to_json (T)
-    FROM (
-          SELECT
-              checkpoints_timed
-            , checkpoints_req
-            , checkpoint_write_time
-            , checkpoint_sync_time
-            , buffers_checkpoint
-            , buffers_clean
-            , maxwritten_clean
-            , buffers_backend
-            , buffers_backend_fsync
-            , buffers_alloc
-          FROM pg_catalog.pg_stat_bgwriter
-		  ) T ;`
-
-	row, err := conn.QueryRow(ctx, query)
+	row, err := conn.QueryRowBuiltin(ctx, keyBgwriter)
 	if err != nil {
 		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
 	}
@@ -0,0 +1,128 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"git.zabbix.com/ap/plugin-support/metric"
+	"git.zabbix.com/ap/plugin-support/zbxerr"
+	"github.com/jackc/pgx/v4"
+)
+
+const keyBuffercache = "pgsql.buffercache"
+
+var _ = registerMetric(keyBuffercache, metricSpec{
+	description: "Returns JSON with analysis of shared buffers contents (requires pg_buffercache).",
+	params: paramsWith(
+		metric.NewParam("TopN", "Number of relations with the most cached buffers to return, "+
+			"0 skips the scan of pg_buffercache they require.").WithDefault("0")),
+	handler: buffercacheHandler,
+})
+
+// pgVersionWithBuffercacheSummary is the version which added pg_buffercache_summary() and
+// pg_buffercache_usage_counts() to pg_buffercache 1.4.
+const pgVersionWithBuffercacheSummary = 160000
+
+// buffercacheHandler analyses contents of shared buffers using the pg_buffercache extension
+// and returns JSON if all is OK or nil otherwise.
+func buffercacheHandler(ctx context.Context, conn PostgresClient,
+	_ string, params map[string]string, _ ...string) (interface{}, error) {
+	var (
+		extVersion      sql.NullString
+		buffercacheJSON string
+	)
+
+	topN, err := strconv.Atoi(params["TopN"])
+	if err != nil {
+		return nil, zbxerr.ErrorInvalidParams.Wrap(
+			fmt.Errorf("TopN must be an integer, %s", err.Error()),
+		)
+	}
+
+	if topN < 0 {
+		return nil, zbxerr.ErrorInvalidParams.Wrap(
+			fmt.Errorf("TopN must be greater than or equal to 0"),
+		)
+	}
+
+	row, err := conn.QueryRowBuiltin(ctx, "pgsql.buffercache.installed")
+	if err != nil {
+		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+	}
+
+	err = row.Scan(&extVersion)
+	if err != nil {
+		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+	}
+
+	if !extVersion.Valid {
+		return nil, zbxerr.ErrorCannotFetchData.Wrap(
+			errors.New("pg_buffercache extension is not installed in the database"),
+		)
+	}
+
+	// The extension is not updated by pg_upgrade, so an older version may be installed on a newer server.
+	if conn.PostgresVersion() >= pgVersionWithBuffercacheSummary && !extensionVersionAtLeast(extVersion.String, 1, 4) {
+		return nil, zbxerr.ErrorCannotFetchData.Wrap(fmt.Errorf(
+			"pg_buffercache extension %s is installed, version 1.4 is required on PostgreSQL %s; "+
+				"run ALTER EXTENSION pg_buffercache UPDATE", extVersion.String, formatVersion(conn.PostgresVersion())),
+		)
+	}
+
+	row, err = conn.QueryRowBuiltin(ctx, keyBuffercache, topN)
+	if err != nil {
+		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+	}
+
+	err = row.Scan(&buffercacheJSON)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, zbxerr.ErrorEmptyResult.Wrap(err)
+		}
+
+		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+	}
+
+	return buffercacheJSON, nil
+}
+
+// extensionVersionAtLeast reports whether an extension version like "1.3" is not older than major.minor.
+func extensionVersionAtLeast(version string, major, minor int) bool {
+	parts := strings.SplitN(version, ".", 3)
+
+	gotMajor, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+
+	gotMinor := 0
+
+	if len(parts) > 1 {
+		if gotMinor, err = strconv.Atoi(parts[1]); err != nil {
+			return false
+		}
+	}
+
+	return gotMajor > major || gotMajor == major && gotMinor >= minor
+}
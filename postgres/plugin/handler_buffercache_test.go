@@ -0,0 +1,109 @@
+//go:build postgresql_tests
+// +build postgresql_tests
+
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+)
+
+func TestPlugin_buffercacheHandler(t *testing.T) {
+	sharedPool, err := getConnPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var extVersion sql.NullString
+
+	err = sharedPool.client.QueryRow(
+		`SELECT (SELECT extversion FROM pg_catalog.pg_extension WHERE extname = 'pg_buffercache')`).Scan(&extVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	installed := extVersion.Valid && (sharedPool.PostgresVersion() < pgVersionWithBuffercacheSummary ||
+		extensionVersionAtLeast(extVersion.String, 1, 4))
+
+	type args struct {
+		ctx         context.Context
+		conn        *PGConn
+		key         string
+		params      map[string]string
+		extraParams []string
+	}
+	tests := []struct {
+		name    string
+		p       *Plugin
+		args    args
+		wantErr bool
+	}{
+		{
+			fmt.Sprintf("buffercacheHandler should return json with data for pgsql.buffercache key if OK"),
+			&Impl,
+			args{context.Background(), sharedPool, keyBuffercache, map[string]string{"TopN": "10"}, []string{}},
+			!installed,
+		},
+		{
+			fmt.Sprintf("buffercacheHandler should return json without top relations if TopN is 0"),
+			&Impl,
+			args{context.Background(), sharedPool, keyBuffercache, map[string]string{"TopN": "0"}, []string{}},
+			!installed,
+		},
+		{
+			fmt.Sprintf("buffercacheHandler should fail on a wrong TopN"),
+			&Impl,
+			args{context.Background(), sharedPool, keyBuffercache, map[string]string{"TopN": "-1"}, []string{}},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := buffercacheHandler(tt.args.ctx, tt.args.conn, tt.args.key, tt.args.params, tt.args.extraParams...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Plugin.buffercacheHandler() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+		})
+	}
+}
+
+func Test_extensionVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{"1.4", true},
+		{"1.10", true},
+		{"2.0", true},
+		{"1.3", false},
+		{"1", false},
+		{"unknown", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			if got := extensionVersionAtLeast(tt.version, 1, 4); got != tt.want {
+				t.Errorf("extensionVersionAtLeast(%q, 1, 4) = %v, want %v", tt.version, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+//go:build postgresql_tests
+// +build postgresql_tests
+
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/omeid/go-yarn"
+)
+
+func TestPlugin_customQueryDatabasesHandler(t *testing.T) {
+	sharedPool, err := getConnPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := sharedPool.queries
+
+	store.mutex.RLock()
+	storage, catalogue := store.storage, store.catalogue
+	store.mutex.RUnlock()
+
+	t.Cleanup(func() { store.set(storage, catalogue) })
+
+	store.set(yarn.NewFromMap(map[string]string{
+		"dbname.sql":  "-- @output: value\nSELECT current_database()",
+		"failing.sql": "-- @output: value\nSELECT 1/0",
+	}), builtinCatalogue)
+
+	_, _, _, pgDb := getEnv()
+
+	tests := []struct {
+		name    string
+		params  map[string]string
+		wantErr bool
+	}{
+		{"custom query", map[string]string{"QueryName": "dbname"}, false},
+		{"built-in query", map[string]string{"QueryName": "pgsql.ping"}, false},
+		{"failing query", map[string]string{"QueryName": "failing"}, false},
+		{"not a key", map[string]string{"QueryName": "pgsql.unknown"}, true},
+		{"fan-out key", map[string]string{"QueryName": keyCustomQueryDatabases}, true},
+		{"filtered", map[string]string{"QueryName": "dbname", "Include": "^" + regexp.QuoteMeta(pgDb) + "$"}, false},
+		{"excluded", map[string]string{"QueryName": "dbname", "Exclude": "."}, false},
+		{"unknown query", map[string]string{"QueryName": "unknown"}, true},
+		{"invalid filter", map[string]string{"QueryName": "dbname", "Include": "("}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := customQueryDatabasesHandler(context.Background(), sharedPool, keyCustomQueryDatabases,
+				tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Plugin.customQueryDatabasesHandler() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			var res map[string]interface{}
+			if err = json.Unmarshal([]byte(got.(string)), &res); err != nil {
+				t.Fatalf("Plugin.customQueryDatabasesHandler() returned invalid JSON %s: %v", got, err)
+			}
+
+			switch tt.name {
+			case "filtered":
+				if !reflect.DeepEqual(res, map[string]interface{}{pgDb: pgDb}) {
+					t.Errorf("Plugin.customQueryDatabasesHandler() = %v, want only %s", res, pgDb)
+				}
+			case "failing query":
+				for dbname, v := range res {
+					if obj, ok := v.(map[string]interface{}); !ok || obj["error"] == nil {
+						t.Errorf("Plugin.customQueryDatabasesHandler() = %v for %s, want an error", v, dbname)
+					}
+				}
+			case "excluded":
+				if len(res) != 0 {
+					t.Errorf("Plugin.customQueryDatabasesHandler() = %v, want no databases", res)
+				}
+			default:
+				if len(res) == 0 {
+					t.Errorf("Plugin.customQueryDatabasesHandler() returned no databases")
+				}
+			}
+		})
+	}
+}
+
+func Test_filterDatabases(t *testing.T) {
+	databases := []string{"postgres", "zabbix", "zabbix_test", "app"}
+
+	tests := []struct {
+		name    string
+		include string
+		exclude string
+		want    []string
+	}{
+		{"no filters", "", "", databases},
+		{"include", "^zabbix", "", []string{"zabbix", "zabbix_test"}},
+		{"exclude", "", "_test$|^postgres$", []string{"zabbix", "app"}},
+		{"both", "^zabbix", "_test$", []string{"zabbix"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			include, err := compileDatabaseFilter(tt.include)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			exclude, err := compileDatabaseFilter(tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := filterDatabases(databases, include, exclude); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterDatabases() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
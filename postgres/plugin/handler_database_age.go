@@ -25,16 +25,20 @@ import (
 	"github.com/jackc/pgx/v4"
 )
 
+const keyDatabaseAge = "pgsql.db.age"
+
+var _ = registerMetric(keyDatabaseAge, metricSpec{
+	description: "Returns age for specific database.",
+	params:      commonParams,
+	handler:     databaseAgeHandler,
+})
+
 // databaseAgeHandler gets age of specific database respectively or nil otherwise.
 func databaseAgeHandler(ctx context.Context, conn PostgresClient,
 	_ string, params map[string]string, _ ...string) (interface{}, error) {
 	var countAge int64
 
-	query := `SELECT age(datfrozenxid)
-		FROM pg_catalog.pg_database
-   		WHERE datistemplate = false
-			 AND datname = $1;`
-	row, err := conn.QueryRow(ctx, query, params["Database"])
+	row, err := conn.QueryRowBuiltin(ctx, keyDatabaseAge, params["Database"])
 
 	if err != nil {
 		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
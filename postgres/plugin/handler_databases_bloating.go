@@ -25,17 +25,20 @@ import (
 	"github.com/jackc/pgx/v4"
 )
 
+const keyDatabasesBloating = "pgsql.db.bloating_tables"
+
+var _ = registerMetric(keyDatabasesBloating, metricSpec{
+	description: "Returns percent of bloating tables for each database.",
+	params:      commonParams,
+	handler:     databasesBloatingHandler,
+})
+
 // databasesBloatingHandler gets info about count and size of archive files and returns JSON if all is OK or nil otherwise.
 func databasesBloatingHandler(ctx context.Context, conn PostgresClient,
 	_ string, _ map[string]string, _ ...string) (interface{}, error) {
 	var countBloating int64
 
-	query := `SELECT count(*)
-				FROM pg_catalog.pg_stat_all_tables
-	   		   WHERE (n_dead_tup/(n_live_tup+n_dead_tup)::float8) > 0.2
-		 		 AND (n_live_tup+n_dead_tup) > 50;`
-
-	row, err := conn.QueryRow(ctx, query)
+	row, err := conn.QueryRowBuiltin(ctx, keyDatabasesBloating)
 	if err != nil {
 		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
 	}
@@ -25,17 +25,20 @@ import (
 	"github.com/jackc/pgx/v4"
 )
 
+const keyDatabasesDiscovery = "pgsql.db.discovery"
+
+var _ = registerMetric(keyDatabasesDiscovery, metricSpec{
+	description: "Returns JSON discovery rule with names of databases.",
+	params:      commonParams,
+	handler:     databasesDiscoveryHandler,
+})
+
 // databasesDiscoveryHandler gets names of all databases and returns JSON if all is OK or nil otherwise.
 func databasesDiscoveryHandler(ctx context.Context, conn PostgresClient,
 	_ string, _ map[string]string, _ ...string) (interface{}, error) {
 	var databasesJSON string
 
-	query := `SELECT json_build_object ('data',json_agg(json_build_object('{#DBNAME}',d.datname)))
-				FROM pg_database d
-			   WHERE NOT datistemplate
-				 AND datallowconn;`
-
-	row, err := conn.QueryRow(ctx, query)
+	row, err := conn.QueryRowBuiltin(ctx, keyDatabasesDiscovery)
 	if err != nil {
 		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
 	}
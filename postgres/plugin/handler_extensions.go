@@ -0,0 +1,176 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"git.zabbix.com/ap/plugin-support/zbxerr"
+)
+
+const (
+	keyExtensions          = "pgsql.extensions"
+	keyExtensionsDiscovery = "pgsql.extensions.discovery"
+)
+
+var _ = registerMetric(keyExtensions, metricSpec{
+	description: "Returns JSON with installed and available versions of extensions for each database.",
+	params:      commonParams,
+	handler:     extensionsHandler,
+})
+
+var _ = registerMetric(keyExtensionsDiscovery, metricSpec{
+	description: "Returns JSON discovery rule with names of extensions for each database.",
+	params:      commonParams,
+	handler:     extensionsHandler,
+})
+
+type extensionInfo struct {
+	InstalledVersion string  `json:"installed_version"`
+	DefaultVersion   *string `json:"default_version"`
+	UpdateAvailable  int     `json:"update_available"`
+}
+
+// extensionsHandler gets installed extensions of each connectable database with their installed and default
+// available versions and returns JSON or LLD JSON if all is OK or nil otherwise. Databases which cannot be
+// connected to or queried are skipped.
+func extensionsHandler(ctx context.Context, conn PostgresClient,
+	key string, _ map[string]string, _ ...string) (interface{}, error) {
+	databases, err := connectableDatabases(ctx, conn)
+	if err != nil {
+		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+	}
+
+	extensions := make(map[string]map[string]extensionInfo, len(databases))
+
+	for _, dbname := range databases {
+		dbConn, err := conn.WithDatabase(dbname)
+		if err != nil {
+			Impl.Errf("[%s] Cannot connect to database %q: %s", Name, dbname, err.Error())
+
+			continue
+		}
+
+		dbExtensions, err := getExtensions(ctx, dbConn)
+		if err != nil {
+			// The other databases cannot be queried either once the call has timed out.
+			if ctx.Err() != nil {
+				return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+			}
+
+			Impl.Errf("[%s] Cannot get extensions of database %q: %s", Name, dbname, err.Error())
+
+			continue
+		}
+
+		extensions[dbname] = dbExtensions
+	}
+
+	if key == keyExtensionsDiscovery {
+		jsonRes, err := json.Marshal(map[string]interface{}{"data": extensionsLLD(extensions)})
+		if err != nil {
+			return nil, zbxerr.ErrorCannotMarshalJSON.Wrap(err)
+		}
+
+		return string(jsonRes), nil
+	}
+
+	jsonRes, err := json.Marshal(extensions)
+	if err != nil {
+		return nil, zbxerr.ErrorCannotMarshalJSON.Wrap(err)
+	}
+
+	return string(jsonRes), nil
+}
+
+// extensionsLLD returns LLD rows of extensions sorted by database and extension names, so the rows do not
+// change their order from poll to poll.
+func extensionsLLD(extensions map[string]map[string]extensionInfo) []map[string]string {
+	lld := make([]map[string]string, 0)
+
+	for dbname, dbExtensions := range extensions {
+		for extname := range dbExtensions {
+			lld = append(lld, map[string]string{"{#DBNAME}": dbname, "{#EXTNAME}": extname})
+		}
+	}
+
+	sort.Slice(lld, func(i, j int) bool {
+		if lld[i]["{#DBNAME}"] != lld[j]["{#DBNAME}"] {
+			return lld[i]["{#DBNAME}"] < lld[j]["{#DBNAME}"]
+		}
+
+		return lld[i]["{#EXTNAME}"] < lld[j]["{#EXTNAME}"]
+	})
+
+	return lld
+}
+
+// connectableDatabases returns names of all non-template databases which allow connections.
+func connectableDatabases(ctx context.Context, conn PostgresClient) ([]string, error) {
+	rows, err := conn.QueryBuiltin(ctx, "pgsql.db.connectable")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var databases []string
+
+	for rows.Next() {
+		var dbname string
+
+		if err = rows.Scan(&dbname); err != nil {
+			return nil, err
+		}
+
+		databases = append(databases, dbname)
+	}
+
+	return databases, rows.Err()
+}
+
+// getExtensions returns extensions installed in the database a given connection is connected to.
+func getExtensions(ctx context.Context, conn PostgresClient) (map[string]extensionInfo, error) {
+	rows, err := conn.QueryBuiltin(ctx, keyExtensions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	extensions := make(map[string]extensionInfo)
+
+	for rows.Next() {
+		var (
+			name string
+			info extensionInfo
+		)
+
+		if err = rows.Scan(&name, &info.InstalledVersion, &info.DefaultVersion); err != nil {
+			return nil, err
+		}
+
+		if info.DefaultVersion != nil && *info.DefaultVersion != info.InstalledVersion {
+			info.UpdateAvailable = 1
+		}
+
+		extensions[name] = info
+	}
+
+	return extensions, rows.Err()
+}
@@ -0,0 +1,117 @@
+//go:build postgresql_tests
+// +build postgresql_tests
+
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPlugin_extensionsHandler(t *testing.T) {
+	sharedPool, err := getConnPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		ctx         context.Context
+		conn        *PGConn
+		key         string
+		params      map[string]string
+		extraParams []string
+	}
+	tests := []struct {
+		name    string
+		p       *Plugin
+		args    args
+		wantErr bool
+	}{
+		{
+			fmt.Sprintf("extensionsHandler should return json with data for pgsql.extensions key if OK"),
+			&Impl,
+			args{context.Background(), sharedPool, keyExtensions, nil, []string{}},
+			false,
+		},
+		{
+			fmt.Sprintf("extensionsHandler should return LLD json for pgsql.extensions.discovery key if OK"),
+			&Impl,
+			args{context.Background(), sharedPool, keyExtensionsDiscovery, nil, []string{}},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extensionsHandler(tt.args.ctx, tt.args.conn, tt.args.key, tt.args.params, tt.args.extraParams...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Plugin.extensionsHandler() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got.(string)) == 0 {
+				t.Errorf("Plugin.extensionsHandler() returned empty result")
+			}
+
+			if tt.args.key != keyExtensionsDiscovery {
+				return
+			}
+
+			var lld struct {
+				Data []map[string]string `json:"data"`
+			}
+
+			if err = json.Unmarshal([]byte(got.(string)), &lld); err != nil {
+				t.Fatalf("Plugin.extensionsHandler() returned invalid JSON: %v", err)
+			}
+
+			if !sort.SliceIsSorted(lld.Data, func(i, j int) bool {
+				a, b := lld.Data[i], lld.Data[j]
+
+				return a["{#DBNAME}"] < b["{#DBNAME}"] ||
+					a["{#DBNAME}"] == b["{#DBNAME}"] && a["{#EXTNAME}"] < b["{#EXTNAME}"]
+			}) {
+				t.Errorf("Plugin.extensionsHandler() returned unsorted LLD rows: %v", lld.Data)
+			}
+		})
+	}
+}
+
+func Test_extensionsLLD(t *testing.T) {
+	extensions := map[string]map[string]extensionInfo{
+		"zabbix":   {"pg_trgm": {}, "pg_stat_statements": {}},
+		"app":      {"postgis": {}},
+		"postgres": {},
+	}
+
+	want := []map[string]string{
+		{"{#DBNAME}": "app", "{#EXTNAME}": "postgis"},
+		{"{#DBNAME}": "zabbix", "{#EXTNAME}": "pg_stat_statements"},
+		{"{#DBNAME}": "zabbix", "{#EXTNAME}": "pg_trgm"},
+	}
+
+	for i := 0; i < 10; i++ {
+		if got := extensionsLLD(extensions); !reflect.DeepEqual(got, want) {
+			t.Fatalf("extensionsLLD() = %v, want %v", got, want)
+		}
+	}
+}
@@ -25,15 +25,20 @@ import (
 	"github.com/jackc/pgx/v4"
 )
 
+const keyOldestXid = "pgsql.oldest.xid"
+
+var _ = registerMetric(keyOldestXid, metricSpec{
+	description: "Returns age of oldest xid.",
+	params:      commonParams,
+	handler:     oldestXIDHandler,
+})
+
 // oldestXIDHandler gets age of the oldest xid if all is OK or nil otherwise.
 func oldestXIDHandler(ctx context.Context, conn PostgresClient,
 	_ string, _ map[string]string, _ ...string) (interface{}, error) {
 	var resultXID int64
 
-	query := `SELECT greatest(max(age(backend_xmin)), max(age(backend_xid)))
-				FROM pg_catalog.pg_stat_activity`
-
-	row, err := conn.QueryRow(ctx, query)
+	row, err := conn.QueryRowBuiltin(ctx, keyOldestXid)
 	if err != nil {
 		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
 	}
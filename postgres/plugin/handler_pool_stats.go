@@ -0,0 +1,69 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+
+	"git.zabbix.com/ap/plugin-support/zbxerr"
+)
+
+const keyPoolStats = "pgsql.plugin.pool"
+
+var _ = registerMetric(keyPoolStats, metricSpec{
+	description: "Returns JSON with statistics of the plugin's connection pool.",
+	params:      commonParams,
+	handler:     poolStatsHandler,
+})
+
+// poolStatsHandler returns JSON with statistics of the plugin's connection pool used for the given connection
+// parameters.
+func poolStatsHandler(_ context.Context, conn PostgresClient,
+	_ string, _ map[string]string, _ ...string) (interface{}, error) {
+	stats := conn.PoolStats()
+
+	jsonRes, err := json.Marshal(struct {
+		MaxOpenConnections int   `json:"max_open_connections"`
+		OpenConnections    int   `json:"open_connections"`
+		InUse              int   `json:"in_use"`
+		Idle               int   `json:"idle"`
+		WaitCount          int64 `json:"wait_count"`
+		WaitDuration       int64 `json:"wait_duration"`
+		MaxIdleClosed      int64 `json:"max_idle_closed"`
+		MaxIdleTimeClosed  int64 `json:"max_idle_time_closed"`
+		MaxLifetimeClosed  int64 `json:"max_lifetime_closed"`
+		CanceledQueries    int64 `json:"canceled_queries"`
+	}{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDuration:       stats.WaitDuration.Milliseconds(),
+		MaxIdleClosed:      stats.MaxIdleClosed,
+		MaxIdleTimeClosed:  stats.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+		CanceledQueries:    conn.CanceledQueries(),
+	})
+	if err != nil {
+		return nil, zbxerr.ErrorCannotMarshalJSON.Wrap(err)
+	}
+
+	return string(jsonRes), nil
+}
@@ -0,0 +1,227 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"git.zabbix.com/ap/plugin-support/zbxerr"
+)
+
+const keyPreflight = "pgsql.preflight"
+
+var _ = registerMetric(keyPreflight, metricSpec{
+	description: "Returns JSON with checks of privileges and prerequisites of the built-in keys.",
+	params:      commonParams,
+	handler:     preflightHandler,
+})
+
+const (
+	preflightPass = "pass"
+	preflightFail = "fail"
+	preflightSkip = "skip"
+
+	// minTrackActivityQuerySize is the size of query texts below which pgsql.queries and pg_stat_activity
+	// based keys are likely to see truncated queries.
+	minTrackActivityQuerySize = 4096
+)
+
+type preflightCheck struct {
+	Check  string `json:"check"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+type preflightResult struct {
+	Checks []preflightCheck `json:"checks"`
+	Failed int              `json:"failed"`
+}
+
+// preflightState holds everything the preflight checks are based on.
+// Nullable fields are NULL if the object does not exist on the server or is hidden from the role.
+type preflightState struct {
+	version                 int
+	superuser               bool
+	pgMonitor               bool
+	lsWaldir                sql.NullBool
+	lsTmpdir                sql.NullBool
+	statStatementsInstalled bool
+	statStatementsAvailable bool
+	statStatementsPreloaded sql.NullBool
+	buffercacheInstalled    bool
+	buffercacheAvailable    bool
+	trackIOTiming           bool
+	trackActivityQuerySize  int
+	trackFunctions          string
+}
+
+// preflightHandler checks the prerequisites of the built-in keys for the monitoring role
+// and returns JSON if all is OK or nil otherwise.
+func preflightHandler(ctx context.Context, conn PostgresClient,
+	_ string, _ map[string]string, _ ...string) (interface{}, error) {
+	state := preflightState{version: conn.PostgresVersion()}
+
+	row, err := conn.QueryRowBuiltin(ctx, keyPreflight)
+	if err != nil {
+		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+	}
+
+	err = row.Scan(&state.pgMonitor, &state.superuser, &state.lsWaldir, &state.lsTmpdir,
+		&state.statStatementsInstalled, &state.statStatementsAvailable, &state.statStatementsPreloaded,
+		&state.buffercacheInstalled, &state.buffercacheAvailable, &state.trackIOTiming,
+		&state.trackActivityQuerySize, &state.trackFunctions)
+	if err != nil {
+		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+	}
+
+	jsonRes, err := json.Marshal(newPreflightResult(state.checks()))
+	if err != nil {
+		return nil, zbxerr.ErrorCannotMarshalJSON.Wrap(err)
+	}
+
+	return string(jsonRes), nil
+}
+
+func newPreflightResult(checks []preflightCheck) preflightResult {
+	res := preflightResult{Checks: checks}
+
+	for _, c := range checks {
+		if c.Status == preflightFail {
+			res.Failed++
+		}
+	}
+
+	return res
+}
+
+// checks evaluates the prerequisites in the order they are reported.
+func (s preflightState) checks() []preflightCheck {
+	return []preflightCheck{
+		s.versionCheck(),
+		s.pgMonitorCheck(),
+		functionCheck("pg_ls_waldir", s.lsWaldir, "pgsql.wal.stat", 100000),
+		functionCheck("pg_ls_tmpdir", s.lsTmpdir, "pgsql.tempfiles", 120000),
+		s.statStatementsCheck(),
+		extensionCheck("pg_buffercache", s.buffercacheInstalled, s.buffercacheAvailable, "pgsql.buffercache"),
+		settingCheck("track_io_timing", s.trackIOTiming,
+			"track_io_timing is off, blk_read_time and blk_write_time of pgsql.dbstat are always 0; "+
+				"set track_io_timing = on"),
+		settingCheck("track_activity_query_size", s.trackActivityQuerySize >= minTrackActivityQuerySize,
+			fmt.Sprintf("track_activity_query_size is %d bytes, longer query texts are truncated; set it to "+
+				"at least %d", s.trackActivityQuerySize, minTrackActivityQuerySize)),
+		settingCheck("track_functions", s.trackFunctions != "none",
+			"track_functions is none, function statistics are not collected; set track_functions = pl or all"),
+	}
+}
+
+func (s preflightState) versionCheck() preflightCheck {
+	c := preflightCheck{Check: "server_version", Status: preflightPass}
+
+	if s.version < MinSupportedPGVersion || s.version > MaxSupportedPGVersion {
+		c.Status = preflightFail
+		c.Reason = fmt.Sprintf("PostgreSQL version %d is out of the supported range %d-%d",
+			s.version, MinSupportedPGVersion/10000, MaxSupportedPGVersion/10000)
+	}
+
+	return c
+}
+
+func (s preflightState) pgMonitorCheck() preflightCheck {
+	c := preflightCheck{Check: "pg_monitor", Status: preflightPass}
+
+	switch {
+	case s.superuser:
+		c.Reason = "the role is a superuser"
+	case !s.pgMonitor:
+		c.Status = preflightFail
+		c.Reason = "the role is not a member of pg_monitor, statistics of other sessions are hidden; " +
+			"run GRANT pg_monitor TO the monitoring role"
+	}
+
+	return c
+}
+
+// statStatementsCheck reports whether pg_stat_statements can be used by custom queries. No built-in key reads it,
+// so the check is informational and is skipped rather than failed if the extension cannot be used.
+func (s preflightState) statStatementsCheck() preflightCheck {
+	c := extensionCheck("pg_stat_statements", s.statStatementsInstalled, s.statStatementsAvailable,
+		"custom queries reading it only")
+	if c.Status != preflightPass {
+		c.Status = preflightSkip
+
+		return c
+	}
+
+	// shared_preload_libraries is visible to superusers and members of pg_read_all_settings only.
+	switch {
+	case !s.statStatementsPreloaded.Valid:
+		c.Status = preflightSkip
+		c.Reason = "shared_preload_libraries is hidden from the role, cannot check that pg_stat_statements is " +
+			"loaded; run GRANT pg_monitor or GRANT pg_read_all_settings TO the monitoring role"
+	case !s.statStatementsPreloaded.Bool:
+		c.Status = preflightSkip
+		c.Reason = "pg_stat_statements is not in shared_preload_libraries, custom queries reading it fail; " +
+			"add it and restart the server"
+	}
+
+	return c
+}
+
+func functionCheck(name string, granted sql.NullBool, key string, minVersion int) preflightCheck {
+	c := preflightCheck{Check: "execute_" + name, Status: preflightPass}
+
+	switch {
+	case !granted.Valid:
+		c.Status = preflightSkip
+		c.Reason = fmt.Sprintf("%s is not available before PostgreSQL %d", name, minVersion/10000)
+	case !granted.Bool:
+		c.Status = preflightFail
+		c.Reason = fmt.Sprintf("no EXECUTE privilege on %s, required by %s; run GRANT pg_monitor or "+
+			"GRANT EXECUTE ON FUNCTION pg_catalog.%s() TO the monitoring role", name, key, name)
+	}
+
+	return c
+}
+
+func extensionCheck(name string, installed, available bool, key string) preflightCheck {
+	c := preflightCheck{Check: name, Status: preflightPass}
+
+	switch {
+	case !available:
+		c.Status = preflightFail
+		c.Reason = fmt.Sprintf("the %s extension is not available on the server, required by %s; "+
+			"install the PostgreSQL contrib package", name, key)
+	case !installed:
+		c.Status = preflightFail
+		c.Reason = fmt.Sprintf("the %s extension is not installed in the database, required by %s; "+
+			"run CREATE EXTENSION %s", name, key, name)
+	}
+
+	return c
+}
+
+func settingCheck(name string, ok bool, reason string) preflightCheck {
+	if ok {
+		return preflightCheck{Check: name, Status: preflightPass}
+	}
+
+	return preflightCheck{Check: name, Status: preflightFail, Reason: reason}
+}
@@ -0,0 +1,188 @@
+//go:build postgresql_tests
+// +build postgresql_tests
+
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPlugin_preflightHandler(t *testing.T) {
+	sharedPool, err := getConnPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		ctx         context.Context
+		conn        *PGConn
+		key         string
+		params      map[string]string
+		extraParams []string
+	}
+	tests := []struct {
+		name    string
+		p       *Plugin
+		args    args
+		wantErr bool
+	}{
+		{
+			fmt.Sprintf("preflightHandler should return json with checks"),
+			&Impl,
+			args{context.Background(), sharedPool, keyPreflight, nil, []string{}},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := preflightHandler(tt.args.ctx, tt.args.conn, tt.args.key, tt.args.params, tt.args.extraParams...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Plugin.preflightHandler() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			var res preflightResult
+			if err = json.Unmarshal([]byte(got.(string)), &res); err != nil {
+				t.Errorf("Plugin.preflightHandler() returned invalid JSON: %v", err)
+			}
+
+			if len(res.Checks) == 0 {
+				t.Errorf("Plugin.preflightHandler() returned no checks")
+			}
+		})
+	}
+}
+
+func Test_preflightState_checks(t *testing.T) {
+	ok := preflightState{
+		version:                 140005,
+		pgMonitor:               true,
+		lsWaldir:                sql.NullBool{Bool: true, Valid: true},
+		lsTmpdir:                sql.NullBool{Bool: true, Valid: true},
+		statStatementsInstalled: true,
+		statStatementsAvailable: true,
+		statStatementsPreloaded: sql.NullBool{Bool: true, Valid: true},
+		buffercacheInstalled:    true,
+		buffercacheAvailable:    true,
+		trackIOTiming:           true,
+		trackActivityQuerySize:  minTrackActivityQuerySize,
+		trackFunctions:          "pl",
+	}
+
+	if res := newPreflightResult(ok.checks()); res.Failed != 0 {
+		t.Errorf("newPreflightResult() failed = %d, want 0: %+v", res.Failed, res.Checks)
+	}
+
+	bad := preflightState{
+		version:                 90600,
+		lsWaldir:                sql.NullBool{Valid: true},
+		statStatementsInstalled: true,
+		statStatementsAvailable: true,
+		statStatementsPreloaded: sql.NullBool{Valid: true},
+		trackActivityQuerySize:  1024,
+		trackFunctions:          "none",
+	}
+
+	want := map[string]string{
+		"server_version":            preflightFail,
+		"pg_monitor":                preflightFail,
+		"execute_pg_ls_waldir":      preflightFail,
+		"execute_pg_ls_tmpdir":      preflightSkip,
+		"pg_stat_statements":        preflightSkip,
+		"pg_buffercache":            preflightFail,
+		"track_io_timing":           preflightFail,
+		"track_activity_query_size": preflightFail,
+		"track_functions":           preflightFail,
+	}
+
+	res := newPreflightResult(bad.checks())
+	if res.Failed != len(want)-2 {
+		t.Errorf("newPreflightResult() failed = %d, want %d", res.Failed, len(want)-2)
+	}
+
+	for _, c := range res.Checks {
+		if c.Status != want[c.Check] {
+			t.Errorf("check %s status = %s, want %s", c.Check, c.Status, want[c.Check])
+		}
+
+		if c.Status != preflightPass && c.Reason == "" {
+			t.Errorf("check %s has no reason", c.Check)
+		}
+	}
+}
+
+func Test_preflightState_statStatementsCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		preloaded sql.NullBool
+		want      string
+	}{
+		{"preloaded", sql.NullBool{Bool: true, Valid: true}, preflightPass},
+		{"not preloaded", sql.NullBool{Valid: true}, preflightSkip},
+		{"hidden setting", sql.NullBool{}, preflightSkip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := preflightState{
+				statStatementsInstalled: true,
+				statStatementsAvailable: true,
+				statStatementsPreloaded: tt.preloaded,
+			}
+
+			if got := s.statStatementsCheck(); got.Status != tt.want {
+				t.Errorf("preflightState.statStatementsCheck() status = %s, want %s", got.Status, tt.want)
+			}
+		})
+	}
+}
+
+func Test_preflightState_statStatementsNotFailed(t *testing.T) {
+	s := preflightState{
+		version:                140005,
+		pgMonitor:              true,
+		lsWaldir:               sql.NullBool{Bool: true, Valid: true},
+		lsTmpdir:               sql.NullBool{Bool: true, Valid: true},
+		buffercacheInstalled:   true,
+		buffercacheAvailable:   true,
+		trackIOTiming:          true,
+		trackActivityQuerySize: minTrackActivityQuerySize,
+		trackFunctions:         "pl",
+	}
+
+	for _, available := range []bool{false, true} {
+		s.statStatementsAvailable = available
+
+		res := newPreflightResult(s.checks())
+		if res.Failed != 0 {
+			t.Errorf("newPreflightResult() failed = %d without pg_stat_statements, want 0: %+v", res.Failed, res.Checks)
+		}
+
+		for _, c := range res.Checks {
+			if c.Check == "pg_stat_statements" && (c.Status != preflightSkip || strings.Contains(c.Reason, "pgsql.")) {
+				t.Errorf("check pg_stat_statements = %+v, want skipped without naming a built-in key", c)
+			}
+		}
+	}
+}
@@ -22,9 +22,19 @@ import (
 	"fmt"
 	"strconv"
 
+	"git.zabbix.com/ap/plugin-support/metric"
 	"git.zabbix.com/ap/plugin-support/zbxerr"
 )
 
+const keyQueries = "pgsql.queries"
+
+var _ = registerMetric(keyQueries, metricSpec{
+	description: "Returns queries statistic.",
+	params: paramsWith(
+		metric.NewParam("TimePeriod", "Execution time limit for count of slow queries.").SetRequired()),
+	handler: queriesHandler,
+})
+
 // queriesHandler executes select from pg_database command and returns JSON if all is OK or nil otherwise.
 func queriesHandler(ctx context.Context, conn PostgresClient,
 	_ string, params map[string]string, _ ...string) (interface{}, error) {
@@ -43,181 +53,7 @@ func queriesHandler(ctx context.Context, conn PostgresClient,
 		)
 	}
 
-	exp := `^(\\s*(--[^\\n]*\\n|/\\*.*\\*/|\\n))*(autovacuum|VACUUM|ANALYZE|REINDEX|CLUSTER|CREATE|ALTER|TRUNCATE|DROP)`
-	query := fmt.Sprintf(`WITH T AS (
-		SELECT
-			db.datname,
-			coalesce(T.query_time_max, 0) query_time_max,
-			coalesce(T.tx_time_max, 0) tx_time_max,
-			coalesce(T.mro_time_max, 0) mro_time_max,
-			coalesce(T.query_time_sum, 0) query_time_sum,
-			coalesce(T.tx_time_sum, 0) tx_time_sum,
-			coalesce(T.mro_time_sum, 0) mro_time_sum,
-			coalesce(T.query_slow_count, 0) query_slow_count,
-			coalesce(T.tx_slow_count, 0) tx_slow_count,
-			coalesce(T.mro_slow_count, 0) mro_slow_count
-		FROM
-			pg_database db NATURAL
-			LEFT JOIN (
-				SELECT
-					datname,
-					extract(
-						epoch
-						FROM
-							now()
-					) :: integer ts,
-					coalesce(
-						max(
-							extract(
-								'epoch'
-								FROM
-									(clock_timestamp() - query_start)
-							) :: integer * (
-								state NOT IN (
-									'idle',
-									'idle in transaction',
-									'idle in transaction (aborted)'
-								)
-								AND query !~* E'%s'
-							) :: integer
-						),
-						0
-					) query_time_max,
-					coalesce(
-						max(
-							extract(
-								'epoch'
-								FROM
-									(clock_timestamp() - query_start)
-							) :: integer * (
-								state NOT IN ('idle')
-								AND query !~* E'%s'
-							) :: integer
-						),
-						0
-					) tx_time_max,
-					coalesce(
-						max(
-							extract(
-								'epoch'
-								FROM
-									(clock_timestamp() - query_start)
-							) :: integer * (
-								state NOT IN ('idle')
-								AND query ~* E'%s'
-							) :: integer
-						),
-						0
-					) mro_time_max,
-					coalesce(
-						sum(
-							extract(
-								'epoch'
-								FROM
-									(clock_timestamp() - query_start)
-							) :: integer * (
-								state NOT IN (
-									'idle',
-									'idle in transaction',
-									'idle in transaction (aborted)'
-								)
-								AND query !~* E'%s'
-							) :: integer
-						),
-						0
-					) query_time_sum,
-					coalesce(
-						sum(
-							extract(
-								'epoch'
-								FROM
-									(clock_timestamp() - query_start)
-							) :: integer * (
-								state NOT IN ('idle')
-								AND query !~* E'%s'
-							) :: integer
-						),
-						0
-					) tx_time_sum,
-					coalesce(
-						sum(
-							extract(
-								'epoch'
-								FROM
-									(clock_timestamp() - query_start)
-							) :: integer * (
-								state NOT IN ('idle')
-								AND query ~* E'%s'
-							) :: integer
-						),
-						0
-					) mro_time_sum,
-					coalesce(
-						sum(
-							(
-								extract(
-									'epoch'
-									FROM
-										(clock_timestamp() - query_start)
-								) > % d
-							) :: integer * (
-								state NOT IN (
-									'idle',
-									'idle in transaction',
-									'idle in transaction (aborted)'
-								)
-								AND query !~* E'%s'
-							) :: integer
-						),
-						0
-					) query_slow_count,
-					coalesce(
-						sum(
-							(
-								extract(
-									'epoch'
-									FROM
-										(clock_timestamp() - query_start)
-								) > % d
-							) :: integer * (
-								state NOT IN ('idle')
-								AND query !~* E'%s'
-							) :: integer
-						),
-						0
-					) tx_slow_count,
-					coalesce(
-						sum(
-							(
-								extract(
-									'epoch'
-									FROM
-										(clock_timestamp() - query_start)
-								) > % d
-							) :: integer * (
-								state NOT IN ('idle')
-								AND query ~* E'%s'
-							) :: integer
-						),
-						0
-					) mro_slow_count
-				FROM
-					pg_stat_activity
-				WHERE
-					pid <> pg_backend_pid()
-				GROUP BY
-					1
-			) T
-		WHERE
-			NOT db.datistemplate
-	)
-	SELECT
-		json_object_agg(datname, row_to_json(T))
-	FROM
-		T`,
-		exp, exp, exp, exp, exp, exp, period, exp, period, exp, period, exp)
-
-	row, err := conn.QueryRow(ctx, query)
+	row, err := conn.QueryRowBuiltin(ctx, keyQueries, period)
 	if err != nil {
 		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
 	}
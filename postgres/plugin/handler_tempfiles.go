@@ -0,0 +1,166 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"git.zabbix.com/ap/plugin-support/metric"
+	"git.zabbix.com/ap/plugin-support/zbxerr"
+)
+
+const keyTempFiles = "pgsql.tempfiles"
+
+var _ = registerMetric(keyTempFiles, metricSpec{
+	description: "Returns JSON with temporary files usage per database, tablespace and backend.",
+	params: paramsWith(
+		metric.NewParam("TopN", "Number of backends holding the most temporary space to return.").WithDefault("10")),
+	handler: tempFilesHandler,
+})
+
+const pgVersionWithLsTmpdir = 120000
+
+type tempFilesStat struct {
+	TempFiles      int64  `json:"temp_files"`
+	TempBytes      int64  `json:"temp_bytes"`
+	TempFilesDelta *int64 `json:"temp_files_delta"`
+	TempBytesDelta *int64 `json:"temp_bytes_delta"`
+}
+
+// tempFilesCounters keeps the previous values of temp_files and temp_bytes counters of a connection
+// to calculate deltas between two polls.
+type tempFilesCounters struct {
+	sync.Mutex
+	stats map[string]tempFilesStat
+}
+
+// tempFilesHandler gets info about temporary files usage and returns JSON if all is OK or nil otherwise.
+func tempFilesHandler(ctx context.Context, conn PostgresClient,
+	_ string, params map[string]string, _ ...string) (interface{}, error) {
+	topN, err := strconv.Atoi(params["TopN"])
+	if err != nil {
+		return nil, zbxerr.ErrorInvalidParams.Wrap(
+			fmt.Errorf("TopN must be an integer, %s", err.Error()),
+		)
+	}
+
+	if topN < 0 {
+		return nil, zbxerr.ErrorInvalidParams.Wrap(
+			fmt.Errorf("TopN must be greater than or equal to 0"),
+		)
+	}
+
+	stats, err := getTempFilesStats(ctx, conn)
+	if err != nil {
+		return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+	}
+
+	result := struct {
+		Databases   map[string]tempFilesStat `json:"databases"`
+		Tablespaces json.RawMessage          `json:"tablespaces"`
+		TopBackends json.RawMessage          `json:"top_backends"`
+	}{
+		Databases:   conn.TempFilesCounters().update(stats),
+		Tablespaces: json.RawMessage("null"),
+		TopBackends: json.RawMessage("null"),
+	}
+
+	if conn.PostgresVersion() >= pgVersionWithLsTmpdir {
+		var tablespaces, backends string
+
+		row, err := conn.QueryRowBuiltin(ctx, "pgsql.tempfiles.tmpdir", topN)
+		if err != nil {
+			return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+		}
+
+		if err = row.Scan(&tablespaces, &backends); err != nil {
+			return nil, zbxerr.ErrorCannotFetchData.Wrap(err)
+		}
+
+		result.Tablespaces = json.RawMessage(tablespaces)
+		result.TopBackends = json.RawMessage(backends)
+	}
+
+	jsonRes, err := json.Marshal(result)
+	if err != nil {
+		return nil, zbxerr.ErrorCannotMarshalJSON.Wrap(err)
+	}
+
+	return string(jsonRes), nil
+}
+
+// getTempFilesStats returns cumulative temp_files and temp_bytes counters of each database.
+func getTempFilesStats(ctx context.Context, conn PostgresClient) (map[string]tempFilesStat, error) {
+	rows, err := conn.QueryBuiltin(ctx, keyTempFiles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]tempFilesStat)
+
+	for rows.Next() {
+		var (
+			dbname string
+			stat   tempFilesStat
+		)
+
+		if err = rows.Scan(&dbname, &stat.TempFiles, &stat.TempBytes); err != nil {
+			return nil, err
+		}
+
+		stats[dbname] = stat
+	}
+
+	return stats, rows.Err()
+}
+
+// update fills deltas of the given counters using the values of the previous poll and remembers
+// the counters for the next poll. Deltas are null on the first poll of a database.
+func (c *tempFilesCounters) update(stats map[string]tempFilesStat) map[string]tempFilesStat {
+	c.Lock()
+	defer c.Unlock()
+
+	for dbname, stat := range stats {
+		if prevStat, ok := c.stats[dbname]; ok {
+			filesDelta := counterDelta(prevStat.TempFiles, stat.TempFiles)
+			bytesDelta := counterDelta(prevStat.TempBytes, stat.TempBytes)
+			stat.TempFilesDelta = &filesDelta
+			stat.TempBytesDelta = &bytesDelta
+			stats[dbname] = stat
+		}
+	}
+
+	c.stats = stats
+
+	return stats
+}
+
+// counterDelta returns a difference between two values of a cumulative counter,
+// assuming the counter has been reset if it decreased.
+func counterDelta(prev, cur int64) int64 {
+	if cur < prev {
+		return cur
+	}
+
+	return cur - prev
+}
@@ -19,11 +19,8 @@ package plugin
 
 import (
 	"context"
-	"net/http"
-	"net/url"
 	"time"
 
-	"git.zabbix.com/ap/plugin-support/tlsconfig"
 	"git.zabbix.com/ap/plugin-support/uri"
 	"git.zabbix.com/ap/plugin-support/zbxerr"
 
@@ -41,8 +38,12 @@ const (
 // Plugin inherits plugin.Base and store plugin-specific data.
 type Plugin struct {
 	plugin.Base
-	connMgr *ConnManager
-	options PluginOptions
+	connMgr     *ConnManager
+	secrets     *secretStore
+	stats       *callStats
+	queryLoader *queryLoader
+	stopReload  chan struct{}
+	options     PluginOptions
 }
 
 // Impl is the pointer to the plugin implementation.
@@ -50,30 +51,49 @@ var Impl Plugin
 
 // Export implements the Exporter interface.
 func (p *Plugin) Export(key string, rawParams []string, _ plugin.ContextProvider) (result interface{}, err error) {
-	params, extraParams, err := metrics[key].EvalParams(rawParams, p.options.Sessions)
+	start := time.Now()
+
+	defer func() {
+		p.stats.record(key, time.Since(start), err)
+	}()
+
+	m, ok := registry[key]
+	if !ok {
+		return nil, zbxerr.ErrorUnsupportedMetric
+	}
+
+	params, extraParams, err := m.metric.EvalParams(rawParams, p.options.Sessions)
 	if err != nil {
 		return nil, err
 	}
 
-	details, err := tlsconfig.CreateDetails(params["sessionName"], params["TLSConnect"],
-		params["TLSCAFile"], params["TLSCertFile"], params["TLSKeyFile"], params["URI"])
-	if err != nil {
+	// Keys describing the plugin itself do not depend on any connection, so they are available even
+	// if PostgreSQL is not.
+	if m.pluginHandler != nil {
+		return m.pluginHandler(p)
+	}
+
+	if err = p.resolveConnParams(rawParams, params); err != nil {
 		return nil, zbxerr.ErrorInvalidConfiguration.Wrap(err)
 	}
 
-	dbname := url.QueryEscape(params["Database"])
+	if err = p.resolveSecrets(params); err != nil {
+		return nil, zbxerr.ErrorInvalidConfiguration.Wrap(err)
+	}
 
-	uri, err := uri.NewWithCreds(params["URI"]+"?dbname="+dbname, params["User"], params["Password"], uriDefaults)
+	tlsOpts, err := newTLSOptions(params)
 	if err != nil {
-		return nil, err
+		return nil, zbxerr.ErrorInvalidConfiguration.Wrap(err)
 	}
 
-	handleMetric := getHandlerFunc(key)
-	if handleMetric == nil {
-		return nil, zbxerr.ErrorUnsupportedMetric
+	rawURI := connURI(params["URI"], params["Database"], params["TargetSessionAttrs"])
+
+	uri, err := uri.NewWithCreds(rawURI, params["User"], params["Password"], uriDefaults)
+	if err != nil {
+		return nil, err
 	}
 
-	conn, err := p.connMgr.GetConnection(*uri, details)
+	conn, err := p.connMgr.GetConnection(*uri, tlsOpts, p.connOptions(params["sessionName"]))
 	if err != nil {
 		// Special logic of processing connection errors should be used if pgsql.ping is requested
 		// because it must return pingFailed if any error occurred.
@@ -81,16 +101,23 @@ func (p *Plugin) Export(key string, rawParams []string, _ plugin.ContextProvider
 			return pingFailed, nil
 		}
 
+		err = classifyError(err, params["User"])
 		p.Errf(err.Error())
 
 		return nil, err
 	}
 
+	if err = m.checkVersion(key, conn.PostgresVersion()); err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(conn.ctx, conn.callTimeout)
 	defer cancel()
 
-	result, err = handleMetric(ctx, conn, key, params, extraParams...)
+	result, err = m.handler(ctx, conn, key, params, extraParams...)
+	conn.countTimeout(ctx, err)
 
+	result, err = keyResult(result, err, params["User"], resultEncoder{numericPrecision: p.options.NumericPrecision})
 	if err != nil {
 		p.Errf(err.Error())
 	}
@@ -98,34 +125,62 @@ func (p *Plugin) Export(key string, rawParams []string, _ plugin.ContextProvider
 	return result, err
 }
 
+// keyResult brings a result and an error of a key handler to the form returned to the agent: server errors
+// are classified and fractional numbers of JSON results are rounded to the precision of the encoder.
+func keyResult(result interface{}, err error, user string, encoder resultEncoder) (interface{}, error) {
+	if err != nil {
+		return result, classifyError(err, user)
+	}
+
+	if s, ok := result.(string); ok {
+		result = encoder.normalizeJSON(s)
+	}
+
+	return result, nil
+}
+
 // Start implements the Runner interface and performs initialization when plugin is activated.
 func (p *Plugin) Start() {
+	queries := newQueryStore(yarn.NewFromMap(map[string]string{}), builtinCatalogue, queryPolicy{
+		readOnly:         p.options.CustomQueriesReadOnly == 1,
+		allow:            parseAllowList(p.options.CustomQueriesAllowList),
+		numericPrecision: p.options.NumericPrecision,
+	})
+
+	if p.options.CustomQueriesPath != "" {
+		p.queryLoader = newQueryLoader(p.options.CustomQueriesPath, queries, p)
+		p.queryLoader.load()
+
+		if p.options.CustomQueriesReloadInterval > 0 {
+			p.stopReload = make(chan struct{})
+			go p.queryLoader.run(time.Duration(p.options.CustomQueriesReloadInterval)*time.Second, p.stopReload)
+		}
+	}
+
 	p.connMgr = NewConnManager(
 		time.Duration(p.options.KeepAlive)*time.Second,
 		time.Duration(p.options.Timeout)*time.Second,
 		time.Duration(p.options.CallTimeout)*time.Second,
 		hkInterval*time.Second,
-		p.setCustomQuery(),
+		queries,
+	)
+	p.stats = newCallStats()
+	p.secrets = newSecretStore(
+		time.Duration(p.options.SecretTTL)*time.Second,
+		time.Duration(p.options.Timeout)*time.Second,
 	)
-}
-
-func (p *Plugin) setCustomQuery() yarn.Yarn {
-	if p.options.CustomQueriesPath == "" {
-		return yarn.NewFromMap(map[string]string{})
-	}
-
-	queryStorage, err := yarn.New(http.Dir(p.options.CustomQueriesPath), "*"+sqlExt)
-	if err != nil {
-		p.Errf(err.Error())
-		// create empty storage if error occurred
-		return yarn.NewFromMap(map[string]string{})
-	}
-
-	return queryStorage
 }
 
 // Stop implements the Runner interface and frees resources when plugin is deactivated.
 func (p *Plugin) Stop() {
+	if p.stopReload != nil {
+		close(p.stopReload)
+		p.stopReload = nil
+	}
+
+	p.queryLoader = nil
 	p.connMgr.Destroy()
 	p.connMgr = nil
+	p.secrets = nil
+	p.stats = nil
 }
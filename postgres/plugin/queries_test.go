@@ -0,0 +1,185 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/omeid/go-yarn"
+)
+
+// testLogger discards log messages.
+type testLogger struct{}
+
+func (testLogger) Tracef(string, ...interface{})   {}
+func (testLogger) Debugf(string, ...interface{})   {}
+func (testLogger) Warningf(string, ...interface{}) {}
+func (testLogger) Infof(string, ...interface{})    {}
+func (testLogger) Errf(string, ...interface{})     {}
+func (testLogger) Critf(string, ...interface{})    {}
+
+// writeQueryFile writes a file with a given modification time, so changes are detected regardless
+// of the file system timestamp resolution.
+func writeQueryFile(t *testing.T, dir, file, sql string, modTime time.Time) {
+	t.Helper()
+
+	path := filepath.Join(dir, file)
+
+	if err := os.WriteFile(path, []byte(sql), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_queryLoader_load(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+
+	writeQueryFile(t, dir, "first.sql", "SELECT 1;", start)
+	writeQueryFile(t, dir, "second.sql", "SELECT 2", start)
+	writeQueryFile(t, dir, "empty.sql", " ; ", start)
+	writeQueryFile(t, dir, "notes.txt", "not a query", start)
+
+	store := newQueryStore(yarn.NewFromMap(map[string]string{}), builtinCatalogue, queryPolicy{})
+	loader := newQueryLoader(dir, store, testLogger{})
+	loader.load()
+
+	status := loader.status()
+	if !reflect.DeepEqual(status.Queries, []string{"first", "second"}) {
+		t.Fatalf("status().Queries = %v, want [first second]", status.Queries)
+	}
+
+	if status.Errors["empty.sql"] != "query is empty" || len(status.Errors) != 1 {
+		t.Errorf("status().Errors = %v, want an error of empty.sql", status.Errors)
+	}
+
+	if status.Reloads != 1 {
+		t.Errorf("status().Reloads = %d, want 1", status.Reloads)
+	}
+
+	if q, ok := store.customQuery("first"); !ok || q.sql != "SELECT 1" {
+		t.Errorf("customQuery(first) = %v, %v, want the file content", q, ok)
+	}
+
+	// Nothing has changed, the store is not updated.
+	loader.load()
+
+	if status = loader.status(); status.Reloads != 1 {
+		t.Errorf("status().Reloads = %d after an unchanged check, want 1", status.Reloads)
+	}
+
+	// A broken edit keeps the previous version, a fixed file and a removed file are applied.
+	writeQueryFile(t, dir, "first.sql", "", start.Add(time.Minute))
+	writeQueryFile(t, dir, "empty.sql", "SELECT 3", start.Add(time.Minute))
+
+	if err := os.Remove(filepath.Join(dir, "second.sql")); err != nil {
+		t.Fatal(err)
+	}
+
+	loader.load()
+
+	status = loader.status()
+	if !reflect.DeepEqual(status.Queries, []string{"empty", "first"}) {
+		t.Errorf("status().Queries = %v, want [empty first]", status.Queries)
+	}
+
+	if status.Errors["first.sql"] != "query is empty" || len(status.Errors) != 1 {
+		t.Errorf("status().Errors = %v, want an error of first.sql", status.Errors)
+	}
+
+	if status.Reloads != 2 {
+		t.Errorf("status().Reloads = %d, want 2", status.Reloads)
+	}
+
+	if q, ok := store.customQuery("first"); !ok || q.sql != "SELECT 1" {
+		t.Errorf("customQuery(first) = %v, %v, want the previous version", q, ok)
+	}
+
+	if _, ok := store.customQuery("second"); ok {
+		t.Errorf("customQuery(second) found a removed query")
+	}
+
+	if q, ok := store.customQuery("empty"); !ok || q.sql != "SELECT 3" {
+		t.Errorf("customQuery(empty) = %v, %v, want the fixed version", q, ok)
+	}
+}
+
+func Test_queryLoader_load_override(t *testing.T) {
+	dir := t.TempDir()
+	store := newQueryStore(nil, nil, queryPolicy{readOnly: true})
+	loader := newQueryLoader(dir, store, testLogger{})
+
+	loader.load()
+
+	if sql, _, _ := store.builtinQuery("pgsql.ping", MinSupportedPGVersion); sql == "SELECT 42" {
+		t.Fatalf("builtinQuery(pgsql.ping) is overridden before the file is created")
+	}
+
+	writeQueryFile(t, dir, "pgsql.ping.sql", "SELECT 42", time.Now())
+	loader.load()
+
+	sql, guarded, err := store.builtinQuery("pgsql.ping", MinSupportedPGVersion)
+	if err != nil || sql != "SELECT 42" || !guarded {
+		t.Errorf("builtinQuery(pgsql.ping) = %q, %v, %v, want the overridden guarded query", sql, guarded, err)
+	}
+}
+
+func Test_queryLoader_load_missingDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	loader := newQueryLoader(dir, newQueryStore(nil, nil, queryPolicy{}), testLogger{})
+
+	loader.load()
+
+	status := loader.status()
+	if !strings.Contains(status.Errors[dir], "missing") || len(status.Queries) != 0 {
+		t.Errorf("status() = %+v, want an error of the directory", status)
+	}
+
+	var nilLoader *queryLoader
+	if status = nilLoader.status(); status.Path != "" || status.Queries == nil || status.Errors == nil {
+		t.Errorf("status() of a nil loader = %+v, want empty lists", status)
+	}
+}
+
+func Test_queryStore_lookup(t *testing.T) {
+	store := newQueryStore(yarn.NewFromMap(map[string]string{
+		"ok.sql":     "SELECT 1",
+		"denied.sql": "SELECT pg_sleep(1)",
+	}), builtinCatalogue, queryPolicy{allow: parseAllowList("public")})
+
+	if q, err := store.lookup("ok"); err != nil || q.name != "ok" {
+		t.Errorf("lookup(ok) = %v, %v, want the query", q, err)
+	}
+
+	if _, err := store.lookup("denied"); err == nil ||
+		!strings.HasSuffix(err.Error(), "query denied failed to load: function pg_sleep is not in the allow-list") {
+		t.Errorf("lookup(denied) error = %v, want the rejection reason", err)
+	}
+
+	if _, err := store.lookup("missing"); err == nil || !strings.HasSuffix(err.Error(), `query "missing" not found`) {
+		t.Errorf("lookup(missing) error = %v, want not found", err)
+	}
+}
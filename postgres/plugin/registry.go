@@ -0,0 +1,110 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"git.zabbix.com/ap/plugin-support/metric"
+	"git.zabbix.com/ap/plugin-support/zbxerr"
+)
+
+// handlerFunc defines an interface must be implemented by handlers.
+type handlerFunc func(ctx context.Context, conn PostgresClient, key string,
+	params map[string]string, extraParams ...string) (res interface{}, err error)
+
+// pluginHandlerFunc defines a handler of a key which is served by the plugin without any connection.
+type pluginHandlerFunc func(p *Plugin) (res interface{}, err error)
+
+// metricSpec describes a key. Zero minVersion and maxVersion mean that the key is not limited by
+// the server version. A key has either a handler or a pluginHandler.
+type metricSpec struct {
+	description   string
+	params        []*metric.Param
+	varParam      bool
+	minVersion    int
+	maxVersion    int
+	handler       handlerFunc
+	pluginHandler pluginHandlerFunc
+}
+
+type registeredMetric struct {
+	metricSpec
+	metric *metric.Metric
+}
+
+// registry holds all keys of the plugin. Handlers add their keys by registerMetric during package
+// initialization, before the keys are passed to the agent in init.
+var registry = make(map[string]*registeredMetric)
+
+// registerMetric adds a key to the registry. It panics on a duplicate key, since it is a programming error.
+// The returned value allows to register keys in package-level declarations.
+func registerMetric(key string, spec metricSpec) string {
+	if _, ok := registry[key]; ok {
+		panic(fmt.Sprintf("key %s is registered twice", key))
+	}
+
+	registry[key] = &registeredMetric{
+		metricSpec: spec,
+		metric:     metric.New(spec.description, spec.params, spec.varParam),
+	}
+
+	return key
+}
+
+// metricSet returns all registered keys as a metric set.
+func metricSet() metric.MetricSet {
+	set := make(metric.MetricSet, len(registry))
+
+	for key, m := range registry {
+		set[key] = m.metric
+	}
+
+	return set
+}
+
+// checkVersion returns an error if the key is not supported by a given server version.
+func (m *registeredMetric) checkVersion(key string, version int) error {
+	return checkVersionRange(key, m.minVersion, m.maxVersion, version)
+}
+
+// checkVersionRange returns an error if a given server version is out of the range supported by something
+// named name. Zero minVersion and maxVersion mean no limit.
+func checkVersionRange(name string, minVersion, maxVersion, version int) error {
+	if minVersion != 0 && version < minVersion {
+		return zbxerr.ErrorUnsupportedMetric.Wrap(fmt.Errorf("%s requires PostgreSQL %s or newer, server version is %s",
+			name, formatVersion(minVersion), formatVersion(version)))
+	}
+
+	if maxVersion != 0 && version > maxVersion {
+		return zbxerr.ErrorUnsupportedMetric.Wrap(fmt.Errorf("%s is not supported since PostgreSQL %s, server "+
+			"version is %s", name, formatVersion(maxVersion+1), formatVersion(version)))
+	}
+
+	return nil
+}
+
+// formatVersion formats a server_version_num value as a major version, e.g. 130004 as 13.
+func formatVersion(version int) string {
+	if version < 100000 {
+		return fmt.Sprintf("%d.%d", version/10000, version/100%100)
+	}
+
+	return fmt.Sprintf("%d", version/10000)
+}
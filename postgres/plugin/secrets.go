@@ -0,0 +1,209 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefixes of references to secrets which can be used instead of a literal session password.
+const (
+	secretFilePrefix = "file:"
+	secretEnvPrefix  = "env:"
+	secretExecPrefix = "exec:"
+)
+
+// fileSecret is a cached content of a file, it is re-read when the file is modified.
+type fileSecret struct {
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+// execSecret is a cached output of a helper program, it is valid until expiresAt.
+type execSecret struct {
+	value     string
+	expiresAt time.Time
+}
+
+// secretStore resolves references to secrets and caches their values.
+type secretStore struct {
+	mutex    sync.Mutex
+	files    map[string]*fileSecret
+	execs    map[string]*execSecret
+	resolved map[string]string
+	ttl      time.Duration
+	timeout  time.Duration
+	now      func() time.Time
+}
+
+// newSecretStore creates a store which caches outputs of helper programs for ttl
+// and waits for a helper to finish for timeout.
+func newSecretStore(ttl, timeout time.Duration) *secretStore {
+	return &secretStore{
+		files:    make(map[string]*fileSecret),
+		execs:    make(map[string]*execSecret),
+		resolved: make(map[string]string),
+		ttl:      ttl,
+		timeout:  timeout,
+		now:      time.Now,
+	}
+}
+
+// isSecretRef checks whether a given value is a reference to a secret rather than a literal.
+func isSecretRef(value string) bool {
+	return strings.HasPrefix(value, secretFilePrefix) || strings.HasPrefix(value, secretEnvPrefix) ||
+		strings.HasPrefix(value, secretExecPrefix)
+}
+
+// resolve returns the value of a given secret reference and its previously resolved value,
+// which is empty if the reference is resolved for the first time. A literal is returned as both values.
+func (s *secretStore) resolve(ref string) (value, previous string, err error) {
+	switch {
+	case strings.HasPrefix(ref, secretFilePrefix):
+		value, err = s.readFile(strings.TrimPrefix(ref, secretFilePrefix))
+	case strings.HasPrefix(ref, secretEnvPrefix):
+		value, err = readEnv(strings.TrimPrefix(ref, secretEnvPrefix))
+	case strings.HasPrefix(ref, secretExecPrefix):
+		value, err = s.runHelper(strings.TrimPrefix(ref, secretExecPrefix))
+	default:
+		return ref, ref, nil
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	s.mutex.Lock()
+	previous = s.resolved[ref]
+	s.resolved[ref] = value
+	s.mutex.Unlock()
+
+	return value, previous, nil
+}
+
+// readFile returns the content of a file without trailing line breaks, it is re-read only if the file is modified.
+func (s *secretStore) readFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if cached, ok := s.files[path]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.value, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	value := strings.TrimRight(string(data), "\r\n")
+	s.files[path] = &fileSecret{modTime: info.ModTime(), size: info.Size(), value: value}
+
+	return value, nil
+}
+
+// readEnv returns the value of an environment variable.
+func readEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %q is not set", name)
+	}
+
+	return value, nil
+}
+
+// runHelper returns the output of a helper program without trailing line breaks.
+// The output is cached for the store's ttl, the helper is not run while holding the store's lock.
+func (s *secretStore) runHelper(command string) (string, error) {
+	s.mutex.Lock()
+	cached, ok := s.execs[command]
+	s.mutex.Unlock()
+
+	if ok && s.now().Before(cached.expiresAt) {
+		return cached.value, nil
+	}
+
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return "", errors.New("empty helper command")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("cannot run helper %q: %w", args[0], err)
+	}
+
+	value := strings.TrimRight(string(out), "\r\n")
+
+	s.mutex.Lock()
+	s.execs[command] = &execSecret{value: value, expiresAt: s.now().Add(s.ttl)}
+	s.mutex.Unlock()
+
+	return value, nil
+}
+
+// resolveSecrets replaces references to secrets in a session password and TLS key password with the secrets' values.
+// If the password has changed since it was resolved last time, connections established with the previous value
+// are closed, so the session reconnects with the new password without waiting for them to expire.
+func (p *Plugin) resolveSecrets(params map[string]string) error {
+	if _, ok := p.options.Sessions[params["sessionName"]]; !ok {
+		return nil
+	}
+
+	if isSecretRef(params["TLSKeyPassword"]) {
+		value, _, err := p.secrets.resolve(params["TLSKeyPassword"])
+		if err != nil {
+			return fmt.Errorf("cannot resolve TLS key password of session %q: %w", params["sessionName"], err)
+		}
+
+		params["TLSKeyPassword"] = value
+	}
+
+	if !isSecretRef(params["Password"]) {
+		return nil
+	}
+
+	value, previous, err := p.secrets.resolve(params["Password"])
+	if err != nil {
+		return fmt.Errorf("cannot resolve password of session %q: %w", params["sessionName"], err)
+	}
+
+	if previous != "" && previous != value {
+		p.Debugf("password of session %q has changed, closing its connections", params["sessionName"])
+		p.connMgr.closeByCredentials(params["User"], previous)
+	}
+
+	params["Password"] = value
+
+	return nil
+}
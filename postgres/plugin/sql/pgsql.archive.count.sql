@@ -0,0 +1,5 @@
+SELECT row_to_json(T)
+  FROM (
+        SELECT archived_count, failed_count
+          FROM pg_stat_archiver
+       ) T;
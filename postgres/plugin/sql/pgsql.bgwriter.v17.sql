@@ -0,0 +1,21 @@
+SELECT row_to_json (T)
+  FROM (
+        SELECT
+            c.num_timed AS checkpoints_timed
+          , c.num_requested AS checkpoints_req
+          , c.write_time AS checkpoint_write_time
+          , c.sync_time AS checkpoint_sync_time
+          , c.buffers_written AS buffers_checkpoint
+          , b.buffers_clean
+          , b.maxwritten_clean
+          , io.writes AS buffers_backend
+          , io.fsyncs AS buffers_backend_fsync
+          , b.buffers_alloc
+        FROM pg_catalog.pg_stat_bgwriter b
+           , pg_catalog.pg_stat_checkpointer c
+           , (SELECT coalesce(sum(writes), 0)::bigint AS writes
+                   , coalesce(sum(fsyncs), 0)::bigint AS fsyncs
+                FROM pg_catalog.pg_stat_io
+               WHERE object = 'relation'
+                 AND backend_type NOT IN ('checkpointer', 'background writer')) io
+       ) T;
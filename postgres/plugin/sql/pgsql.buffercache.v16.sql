@@ -0,0 +1,30 @@
+SELECT row_to_json(T)
+  FROM (
+	SELECT S.*,
+		(SELECT json_object_agg(usage_count, buffers ORDER BY usage_count)
+			FROM pg_buffercache_usage_counts()) AS usage_counts,
+		(
+			SELECT COALESCE(json_agg(R), '[]')
+			FROM (
+				SELECT
+					c.oid::regclass::text AS relation,
+					count(*) AS buffers,
+					count(*) FILTER (WHERE b.isdirty) AS buffers_dirty
+				FROM pg_buffercache b
+				JOIN pg_catalog.pg_class c ON b.relfilenode = pg_catalog.pg_relation_filenode(c.oid)
+				WHERE b.reldatabase IN (0, (SELECT oid FROM pg_catalog.pg_database
+											 WHERE datname = pg_catalog.current_database()))
+					AND $1 > 0
+				GROUP BY c.oid
+				ORDER BY buffers DESC
+				LIMIT $1
+			) R
+		) AS top_relations
+	FROM (SELECT
+			buffers_used,
+			buffers_unused,
+			buffers_dirty,
+			buffers_pinned,
+			round(usagecount_avg::numeric, 2) AS usagecount_avg
+		FROM pg_buffercache_summary()) S
+) T;
@@ -0,0 +1,5 @@
+SELECT datname
+  FROM pg_catalog.pg_database
+ WHERE NOT datistemplate
+   AND datallowconn
+ ORDER BY datname;
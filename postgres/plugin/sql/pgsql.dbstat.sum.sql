@@ -0,0 +1,22 @@
+SELECT row_to_json (T)
+  FROM  (
+    SELECT
+      sum(numbackends) as numbackends
+    , sum(xact_commit) as xact_commit
+    , sum(xact_rollback) as xact_rollback
+    , sum(blks_read) as blks_read
+    , sum(blks_hit) as blks_hit
+    , sum(tup_returned) as tup_returned
+    , sum(tup_fetched) as tup_fetched
+    , sum(tup_inserted) as tup_inserted
+    , sum(tup_updated) as tup_updated
+    , sum(tup_deleted) as tup_deleted
+    , sum(conflicts) as conflicts
+    , sum(temp_files) as temp_files
+    , sum(temp_bytes) as temp_bytes
+    , sum(deadlocks) as deadlocks
+    , null as checksum_failures
+    , sum(blk_read_time) as blk_read_time
+    , sum(blk_write_time) as blk_write_time
+    FROM pg_catalog.pg_stat_database
+  ) T ;
@@ -0,0 +1,2 @@
+SELECT json_build_object('data',COALESCE(json_agg(json_build_object('{#APPLICATION_NAME}',application_name)), '[]'))
+  FROM pg_stat_replication;
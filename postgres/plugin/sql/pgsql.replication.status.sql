@@ -0,0 +1 @@
+SELECT COUNT(*) FROM pg_stat_wal_receiver;
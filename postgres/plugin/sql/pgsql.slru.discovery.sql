@@ -0,0 +1,2 @@
+SELECT json_build_object('data', COALESCE(json_agg(json_build_object('{#SLRU}', name)), '[]'))
+  FROM pg_catalog.pg_stat_slru;
@@ -0,0 +1,13 @@
+SELECT json_object_agg(name, row_to_json(T))
+  FROM (
+        SELECT
+            name,
+            blks_zeroed,
+            blks_hit,
+            blks_read,
+            blks_written,
+            blks_exists,
+            flushes,
+            truncates
+        FROM pg_catalog.pg_stat_slru
+       ) T;
@@ -0,0 +1,3 @@
+SELECT datname, temp_files, temp_bytes
+  FROM pg_catalog.pg_stat_database
+ WHERE datname IS NOT NULL;
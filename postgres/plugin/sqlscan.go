@@ -0,0 +1,500 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+type sqlTokenKind int
+
+const (
+	tokenIdent sqlTokenKind = iota
+	tokenQuotedIdent
+	tokenString
+	tokenNumber
+	tokenParam
+	tokenPunct
+)
+
+// sqlToken is a lexical token of an SQL text. Unquoted identifiers are folded to lower case the same way
+// as PostgreSQL does, quoted identifiers are unquoted.
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+}
+
+func (t sqlToken) is(kind sqlTokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+func (t sqlToken) isName() bool {
+	return t.kind == tokenIdent || t.kind == tokenQuotedIdent
+}
+
+// scanSQL splits an SQL text into tokens skipping whitespace and comments. It recognizes string constants
+// including escape and dollar-quoted ones, so their contents are never mistaken for SQL.
+func scanSQL(text string) ([]sqlToken, error) {
+	var tokens []sqlToken
+
+	for i := 0; i < len(text); {
+		c := text[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(text[i:], "--"):
+			end := strings.IndexByte(text[i:], '\n')
+			if end < 0 {
+				return tokens, nil
+			}
+
+			i += end + 1
+		case strings.HasPrefix(text[i:], "/*"):
+			end, err := skipBlockComment(text, i)
+			if err != nil {
+				return nil, err
+			}
+
+			i = end
+		case c == '\'':
+			end, err := skipString(text, i, false)
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, sqlToken{tokenString, text[i:end]})
+			i = end
+		case c == '"':
+			name, end, err := scanQuotedIdent(text, i)
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, sqlToken{tokenQuotedIdent, name})
+			i = end
+		case c == '$' && i+1 < len(text) && isDigit(text[i+1]):
+			end := i + 1
+			for end < len(text) && isDigit(text[end]) {
+				end++
+			}
+
+			tokens = append(tokens, sqlToken{tokenParam, text[i:end]})
+			i = end
+		case c == '$':
+			end, err := skipDollarString(text, i)
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, sqlToken{tokenString, text[i:end]})
+			i = end
+		case isDigit(c) || c == '.' && i+1 < len(text) && isDigit(text[i+1]):
+			end := scanNumber(text, i)
+			tokens = append(tokens, sqlToken{tokenNumber, text[i:end]})
+			i = end
+		case isIdentStart(text, i):
+			end := i
+			for end < len(text) && isIdentChar(text, end) {
+				end++
+			}
+
+			word := strings.ToLower(text[i:end])
+
+			// Prefixed string constants: E'...' with backslash escapes, B'...', X'...' and N'...'.
+			if end < len(text) && text[end] == '\'' && (word == "e" || word == "b" || word == "x" || word == "n") {
+				strEnd, err := skipString(text, end, word == "e")
+				if err != nil {
+					return nil, err
+				}
+
+				tokens = append(tokens, sqlToken{tokenString, text[i:strEnd]})
+				i = strEnd
+
+				continue
+			}
+
+			tokens = append(tokens, sqlToken{tokenIdent, word})
+			i = end
+		case strings.HasPrefix(text[i:], "::"):
+			tokens = append(tokens, sqlToken{tokenPunct, "::"})
+			i += 2
+		default:
+			_, size := utf8.DecodeRuneInString(text[i:])
+			tokens = append(tokens, sqlToken{tokenPunct, text[i : i+size]})
+			i += size
+		}
+	}
+
+	return tokens, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(text string, i int) bool {
+	c := text[i]
+
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= utf8.RuneSelf
+}
+
+func isIdentChar(text string, i int) bool {
+	return isIdentStart(text, i) || isDigit(text[i]) || text[i] == '$'
+}
+
+func skipBlockComment(text string, start int) (int, error) {
+	depth := 0
+
+	for i := start; i < len(text)-1; i++ {
+		switch text[i : i+2] {
+		case "/*":
+			depth++
+			i++
+		case "*/":
+			depth--
+			i++
+
+			if depth == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+
+	return 0, errors.New("unterminated comment")
+}
+
+// skipString returns the end of a quoted string constant starting at start. Backslashes escape characters
+// only in escape strings.
+func skipString(text string, start int, escapes bool) (int, error) {
+	for i := start + 1; i < len(text); i++ {
+		switch {
+		case escapes && text[i] == '\\':
+			i++
+		case text[i] == '\'':
+			if i+1 < len(text) && text[i+1] == '\'' {
+				i++
+
+				continue
+			}
+
+			return i + 1, nil
+		}
+	}
+
+	return 0, errors.New("unterminated string constant")
+}
+
+func scanQuotedIdent(text string, start int) (string, int, error) {
+	var name strings.Builder
+
+	for i := start + 1; i < len(text); i++ {
+		if text[i] == '"' {
+			if i+1 < len(text) && text[i+1] == '"' {
+				name.WriteByte('"')
+				i++
+
+				continue
+			}
+
+			return name.String(), i + 1, nil
+		}
+
+		name.WriteByte(text[i])
+	}
+
+	return "", 0, errors.New("unterminated quoted identifier")
+}
+
+// skipDollarString returns the end of a dollar-quoted string constant, e.g. $tag$text$tag$, starting at start.
+func skipDollarString(text string, start int) (int, error) {
+	end := start + 1
+	for end < len(text) && text[end] != '$' && isIdentChar(text, end) {
+		end++
+	}
+
+	if end >= len(text) || text[end] != '$' {
+		return 0, fmt.Errorf("unexpected character %q", text[start])
+	}
+
+	tag := text[start : end+1]
+
+	closing := strings.Index(text[end+1:], tag)
+	if closing < 0 {
+		return 0, errors.New("unterminated dollar-quoted string")
+	}
+
+	return end + 1 + closing + len(tag), nil
+}
+
+func scanNumber(text string, start int) int {
+	i := start
+	for i < len(text) && (isDigit(text[i]) || text[i] == '.') {
+		i++
+	}
+
+	if i < len(text) && (text[i] == 'e' || text[i] == 'E') {
+		j := i + 1
+		if j < len(text) && (text[j] == '+' || text[j] == '-') {
+			j++
+		}
+
+		if j < len(text) && isDigit(text[j]) {
+			i = j
+			for i < len(text) && isDigit(text[i]) {
+				i++
+			}
+		}
+	}
+
+	return i
+}
+
+// checkSingleStatement returns an error if an SQL text contains more than one statement.
+func checkSingleStatement(tokens []sqlToken) error {
+	for i, t := range tokens {
+		if t.is(tokenPunct, ";") {
+			for _, rest := range tokens[i+1:] {
+				if !rest.is(tokenPunct, ";") {
+					return errors.New("query contains multiple statements, only a single statement is allowed")
+				}
+			}
+
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// sqlNotFunctions are keywords which may be followed by a parenthesis without being a function call.
+var sqlNotFunctions = map[string]bool{
+	"all": true, "and": true, "any": true, "array": true, "as": true, "between": true, "case": true,
+	"cast": true, "coalesce": true, "conflict": true, "copy": true, "cube": true, "distinct": true,
+	"else": true, "except": true, "exists": true, "extract": true, "filter": true, "from": true,
+	"greatest": true, "grouping": true, "ilike": true, "in": true, "intersect": true, "interval": true,
+	"into": true, "is": true, "join": true, "lateral": true, "least": true, "like": true, "not": true,
+	"nullif": true, "on": true, "or": true, "over": true, "overlay": true, "position": true,
+	"recursive": true, "rollup": true, "row": true, "select": true, "set": true, "sets": true,
+	"some": true, "substring": true, "table": true, "then": true, "trim": true, "union": true,
+	"update": true, "using": true, "values": true, "when": true, "where": true, "with": true,
+	"within": true,
+}
+
+// sqlFunctionLike are keywords with function call syntax, FROM inside their parentheses does not start
+// a list of relations.
+var sqlFunctionLike = map[string]bool{
+	"cast": true, "coalesce": true, "extract": true, "greatest": true, "least": true, "nullif": true,
+	"overlay": true, "position": true, "substring": true, "trim": true,
+}
+
+// sqlFromListEnd are keywords which end a list of relations following FROM.
+var sqlFromListEnd = map[string]bool{
+	"except": true, "fetch": true, "for": true, "group": true, "having": true, "intersect": true,
+	"limit": true, "offset": true, "order": true, "returning": true, "select": true, "union": true,
+	"where": true, "window": true,
+}
+
+// allowList holds names of schemas and functions custom queries may reference. An entry without a dot
+// allows a schema or an unqualified function name, an entry with a dot allows a schema-qualified function.
+type allowList struct {
+	schemas   map[string]bool
+	functions map[string]bool
+}
+
+// parseAllowList parses a comma-separated list of names. It returns nil if the list is empty.
+func parseAllowList(s string) *allowList {
+	var a *allowList
+
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		if a == nil {
+			a = &allowList{schemas: make(map[string]bool), functions: make(map[string]bool)}
+		}
+
+		if !strings.Contains(name, ".") {
+			a.schemas[name] = true
+		}
+
+		a.functions[name] = true
+	}
+
+	return a
+}
+
+// searchPath returns the allowed schemas quoted for search_path.
+func (a *allowList) searchPath() string {
+	names := make([]string, 0, len(a.schemas))
+	for name := range a.schemas {
+		names = append(names, `"`+strings.ReplaceAll(name, `"`, `""`)+`"`)
+	}
+
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// checkFunction returns an error if a function is not allowed. Unqualified functions are considered
+// to be pg_catalog ones.
+func (a *allowList) checkFunction(parts []string) error {
+	name := parts[len(parts)-1]
+	schema := "pg_catalog"
+
+	if len(parts) > 1 {
+		schema = parts[len(parts)-2]
+	} else if a.functions[name] {
+		return nil
+	}
+
+	if a.schemas[schema] || a.functions[schema+"."+name] {
+		return nil
+	}
+
+	return fmt.Errorf("function %s is not in the allow-list", strings.Join(parts, "."))
+}
+
+// checkRelation returns an error if a schema-qualified relation is not allowed. Unqualified relations
+// are resolved by search_path.
+func (a *allowList) checkRelation(parts []string) error {
+	if len(parts) < 2 || a.schemas[parts[len(parts)-2]] {
+		return nil
+	}
+
+	return fmt.Errorf("schema %s of relation %s is not in the allow-list", parts[len(parts)-2],
+		strings.Join(parts, "."))
+}
+
+// sqlTargetKeywords are keywords followed by a relation which is written or copied. The relation may be followed
+// by a column list, which is not a function call.
+var sqlTargetKeywords = map[string]bool{"copy": true, "into": true, "update": true}
+
+// check returns an error if a query calls a function or references a schema-qualified relation which is not
+// allowed, including targets of INSERT INTO, UPDATE and COPY.
+func (a *allowList) check(tokens []sqlToken) error {
+	type frame struct {
+		function bool
+		fromList bool
+	}
+
+	stack := []frame{{}}
+	expectRelation, expectTarget, skipName, funcParen := false, false, false, false
+	prevKeyword := ""
+
+	for i := 0; i < len(tokens); {
+		t := tokens[i]
+		top := &stack[len(stack)-1]
+
+		if !t.isName() {
+			switch {
+			case t.is(tokenPunct, "("):
+				stack = append(stack, frame{function: funcParen})
+				expectRelation, expectTarget = false, false
+			case t.is(tokenPunct, ")"):
+				if len(stack) > 1 {
+					stack = stack[:len(stack)-1]
+				}
+			case t.is(tokenPunct, ","):
+				expectRelation = top.fromList
+			}
+
+			// A type name follows a cast.
+			skipName = t.is(tokenPunct, "::")
+			funcParen = false
+			prevKeyword = ""
+			i++
+
+			continue
+		}
+
+		// Read a possibly qualified name.
+		parts := []string{t.text}
+		j := i + 1
+
+		for j+1 < len(tokens) && tokens[j].is(tokenPunct, ".") && tokens[j+1].isName() {
+			parts = append(parts, tokens[j+1].text)
+			j += 2
+		}
+
+		call := j < len(tokens) && tokens[j].is(tokenPunct, "(")
+		keyword := t.kind == tokenIdent && len(parts) == 1
+		funcParen = false
+
+		switch {
+		case skipName:
+			skipName = false
+		case keyword && sqlNotFunctions[t.text] || keyword && sqlFromListEnd[t.text]:
+			switch {
+			case t.text == "from" && !top.function && prevKeyword != "distinct":
+				top.fromList = true
+				expectRelation = true
+			case t.text == "join" || t.text == "table":
+				expectRelation = true
+			case t.text == "using" && !top.function:
+				// USING of DELETE and MERGE lists relations, USING of a join is followed by a column list.
+				top.fromList = true
+				expectRelation = true
+			case sqlTargetKeywords[t.text]:
+				expectTarget = true
+			case t.text == "as":
+				skipName = true
+			case sqlFromListEnd[t.text]:
+				top.fromList = false
+				expectRelation = false
+			}
+
+			funcParen = sqlFunctionLike[t.text]
+		case keyword && t.text == "only":
+		case expectTarget:
+			if err := a.checkRelation(parts); err != nil {
+				return err
+			}
+
+			expectTarget = false
+		case call:
+			if err := a.checkFunction(parts); err != nil {
+				return err
+			}
+
+			funcParen = true
+			expectRelation = false
+		case expectRelation:
+			if err := a.checkRelation(parts); err != nil {
+				return err
+			}
+
+			expectRelation = false
+		}
+
+		prevKeyword = ""
+		if keyword {
+			prevKeyword = t.text
+		}
+
+		i = j
+	}
+
+	return nil
+}
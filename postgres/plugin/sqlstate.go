@@ -0,0 +1,140 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"git.zabbix.com/ap/plugin-support/zbxerr"
+	"github.com/jackc/pgconn"
+)
+
+// SQLSTATE codes and classes the plugin gives hints for.
+// See https://www.postgresql.org/docs/current/errcodes-appendix.html.
+const (
+	sqlStateClassAuth        = "28"
+	sqlStateReadOnly         = "25006"
+	sqlStateSerialization    = "40001"
+	sqlStateUndefinedColumn  = "42703"
+	sqlStateUndefinedFunc    = "42883"
+	sqlStateUndefinedTable   = "42P01"
+	sqlStateUndefinedObject  = "42704"
+	sqlStatePrivilege        = "42501"
+	sqlStateTooManyConns     = "53300"
+	sqlStateNotInPrereqState = "55000"
+	sqlStateQueryCanceled    = "57014"
+)
+
+var (
+	errorAuthFailed            = zbxerr.New("authentication failed")
+	errorInsufficientPrivilege = zbxerr.New("insufficient privilege")
+	errorUndefinedObject       = zbxerr.New("undefined object")
+	errorQueryCanceled         = zbxerr.New("query canceled")
+	errorRecoveryConflict      = zbxerr.New("server is in recovery or read-only")
+	errorTooManyConnections    = zbxerr.New("too many connections")
+)
+
+// objectRequirements maps objects which are not available on every server to what provides them.
+var objectRequirements = map[string]string{
+	"pg_stat_statements": "the pg_stat_statements extension: add it to shared_preload_libraries " +
+		"and run CREATE EXTENSION pg_stat_statements",
+	"pg_buffercache":              "the pg_buffercache extension: run CREATE EXTENSION pg_buffercache",
+	"pg_buffercache_summary":      "PostgreSQL 16 or newer with the pg_buffercache extension 1.4 or newer",
+	"pg_buffercache_usage_counts": "PostgreSQL 16 or newer with the pg_buffercache extension 1.4 or newer",
+	"pg_stat_slru":                "PostgreSQL 13 or newer",
+	"pg_stat_wal":                 "PostgreSQL 14 or newer",
+	"pg_ls_tmpdir":                "PostgreSQL 12 or newer",
+	"pg_ls_waldir":                "PostgreSQL 10 or newer",
+	"checksum_failures":           "PostgreSQL 12 or newer",
+}
+
+var (
+	deniedFunctionRe  = regexp.MustCompile(`^permission denied for function "?([^"\s(]+)`)
+	undefinedObjectRe = regexp.MustCompile(`^(?:relation|function|column|type|schema|extension) "?([^"\s(]+)`)
+)
+
+// classifyError turns errors reported by PostgreSQL into errors that tell what to grant or configure.
+// Other errors, e.g. network ones, are returned as is.
+func classifyError(err error, user string) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch {
+	case strings.HasPrefix(pgErr.Code, sqlStateClassAuth):
+		return errorAuthFailed.Wrap(fmt.Errorf("%w; check the user and password of the session or key "+
+			"parameters and the pg_hba.conf rules of the server for user %q", pgErr, user))
+	case pgErr.Code == sqlStatePrivilege:
+		return errorInsufficientPrivilege.Wrap(fmt.Errorf("%w; %s", pgErr, privilegeHint(pgErr.Message, user)))
+	case pgErr.Code == sqlStateUndefinedTable, pgErr.Code == sqlStateUndefinedFunc,
+		pgErr.Code == sqlStateUndefinedColumn, pgErr.Code == sqlStateUndefinedObject:
+		return errorUndefinedObject.Wrap(fmt.Errorf("%w; %s", pgErr, undefinedHint(pgErr.Message)))
+	case pgErr.Code == sqlStateQueryCanceled:
+		return errorQueryCanceled.Wrap(fmt.Errorf("%w; the query exceeded CallTimeout or statement_timeout "+
+			"of the session, or was canceled by an administrator", pgErr))
+	case pgErr.Code == sqlStateReadOnly,
+		pgErr.Code == sqlStateSerialization && strings.Contains(pgErr.Message, "conflict with recovery"),
+		pgErr.Code == sqlStateNotInPrereqState && strings.Contains(pgErr.Message, "recovery"):
+		return errorRecoveryConflict.Wrap(fmt.Errorf("%w; the key requires a primary server, set "+
+			"TargetSessionAttrs to read-write or use the key on the primary; for conflicts with recovery "+
+			"consider hot_standby_feedback or max_standby_streaming_delay on the standby", pgErr))
+	case pgErr.Code == sqlStateTooManyConns:
+		return errorTooManyConnections.Wrap(fmt.Errorf("%w; increase max_connections or the connection "+
+			"limit of user %q, or lower MaxOpenConns of the plugin", pgErr, user))
+	}
+
+	return err
+}
+
+func privilegeHint(message, user string) string {
+	role := quoteRole(user)
+
+	if m := deniedFunctionRe.FindStringSubmatch(message); m != nil {
+		return fmt.Sprintf("run GRANT pg_monitor TO %s or GRANT EXECUTE ON FUNCTION %s TO %s", role, m[1], role)
+	}
+
+	return fmt.Sprintf("run GRANT pg_monitor TO %s, or at least grant pg_read_all_stats and "+
+		"pg_read_all_settings to it", role)
+}
+
+func undefinedHint(message string) string {
+	if m := undefinedObjectRe.FindStringSubmatch(message); m != nil {
+		name := m[1]
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			name = name[i+1:]
+		}
+
+		if req, ok := objectRequirements[name]; ok {
+			return "the key requires " + req
+		}
+	}
+
+	return "the key requires an extension or a PostgreSQL version the server does not have"
+}
+
+func quoteRole(user string) string {
+	if user == "" {
+		return "<monitoring role>"
+	}
+
+	return `"` + strings.ReplaceAll(user, `"`, `""`) + `"`
+}
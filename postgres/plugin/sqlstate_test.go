@@ -0,0 +1,140 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"git.zabbix.com/ap/plugin-support/zbxerr"
+	"github.com/jackc/pgconn"
+)
+
+func Test_classifyError(t *testing.T) {
+	pgErr := func(code, message string) error {
+		return zbxerr.ErrorCannotFetchData.Wrap(&pgconn.PgError{Severity: "ERROR", Code: code, Message: message})
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		wantKind error
+		wantHint string
+	}{
+		{
+			"auth",
+			zbxerr.ErrorConnectionFailed.Wrap(pgErr("28P01", `password authentication failed for user "zbx"`)),
+			errorAuthFailed,
+			"pg_hba.conf",
+		},
+		{
+			"function privilege",
+			pgErr("42501", "permission denied for function pg_ls_waldir"),
+			errorInsufficientPrivilege,
+			`GRANT EXECUTE ON FUNCTION pg_ls_waldir TO "zbx"`,
+		},
+		{
+			"relation privilege",
+			pgErr("42501", "permission denied for table pg_authid"),
+			errorInsufficientPrivilege,
+			`GRANT pg_monitor TO "zbx", or at least grant pg_read_all_stats`,
+		},
+		{
+			"missing extension",
+			pgErr("42P01", `relation "pg_stat_statements" does not exist`),
+			errorUndefinedObject,
+			"shared_preload_libraries",
+		},
+		{
+			"missing function",
+			pgErr("42883", "function pg_catalog.pg_ls_tmpdir() does not exist"),
+			errorUndefinedObject,
+			"PostgreSQL 12 or newer",
+		},
+		{
+			"unknown object",
+			pgErr("42P01", `relation "foo" does not exist`),
+			errorUndefinedObject,
+			"an extension or a PostgreSQL version",
+		},
+		{"canceled", pgErr("57014", "canceling statement due to statement timeout"), errorQueryCanceled, "CallTimeout"},
+		{
+			"recovery",
+			pgErr("55000", "recovery is in progress"),
+			errorRecoveryConflict,
+			"TargetSessionAttrs",
+		},
+		{
+			"recovery conflict",
+			pgErr("40001", "canceling statement due to conflict with recovery"),
+			errorRecoveryConflict,
+			"hot_standby_feedback",
+		},
+		{"too many connections", pgErr("53300", "sorry, too many clients already"), errorTooManyConnections, "max_connections"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyError(tt.err, "zbx")
+			if !strings.HasPrefix(err.Error(), tt.wantKind.Error()) {
+				t.Errorf("classifyError() = %v, want %v", err, tt.wantKind)
+			}
+
+			if !strings.Contains(err.Error(), tt.wantHint) {
+				t.Errorf("classifyError() = %v, want hint %q", err, tt.wantHint)
+			}
+
+			var target *pgconn.PgError
+			if !errors.As(err, &target) {
+				t.Errorf("classifyError() = %v, PgError is lost", err)
+			}
+		})
+	}
+
+	t.Run("other errors", func(t *testing.T) {
+		for _, err := range []error{
+			zbxerr.ErrorConnectionFailed.Wrap(errors.New("dial tcp: connection refused")),
+			pgErr("22012", "division by zero"),
+			pgErr("55000", "object is in use"),
+			pgErr("40001", "could not serialize access due to concurrent update"),
+		} {
+			if got := classifyError(err, "zbx"); got != err {
+				t.Errorf("classifyError() = %v, want %v", got, err)
+			}
+		}
+	})
+}
+
+func Test_keyResult(t *testing.T) {
+	encoder := resultEncoder{numericPrecision: 1}
+
+	res, err := keyResult(`{"b":1.25,"a":2}`, nil, "zbx", encoder)
+	if err != nil || res != `{"b":1.3,"a":2}` {
+		t.Errorf("keyResult() = %v, %v, want a rounded JSON", res, err)
+	}
+
+	res, err = keyResult(int64(1), nil, "zbx", encoder)
+	if err != nil || res != int64(1) {
+		t.Errorf("keyResult() = %v, %v, want the result unchanged", res, err)
+	}
+
+	_, err = keyResult(nil, zbxerr.ErrorCannotFetchData.Wrap(&pgconn.PgError{Code: "53300"}), "zbx", encoder)
+	if err == nil || !strings.HasPrefix(err.Error(), errorTooManyConnections.Error()) {
+		t.Errorf("keyResult() error = %v, want a classified error", err)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_callStats_snapshot(t *testing.T) {
+	s := newCallStats()
+
+	for i := 1; i <= 100; i++ {
+		var err error
+		if i%10 == 0 {
+			err = errors.New("fail")
+		}
+
+		s.record(keyPing, time.Duration(i)*time.Millisecond, err)
+	}
+
+	want := keyStatsJSON{Calls: 100, Errors: 10, P50: 50, P90: 90, P99: 99, Max: 100}
+
+	if got := s.snapshot()[keyPing]; got != want {
+		t.Errorf("callStats.snapshot() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_callStats_record_window(t *testing.T) {
+	s := newCallStats()
+
+	for i := 0; i < latencySamples; i++ {
+		s.record(keyPing, time.Second, nil)
+	}
+
+	for i := 0; i < latencySamples; i++ {
+		s.record(keyPing, time.Millisecond, nil)
+	}
+
+	got := s.snapshot()[keyPing]
+	if got.Calls != 2*latencySamples || got.Max != 1 {
+		t.Errorf("callStats.snapshot() = %+v, want %d calls and only the latest latencies", got, 2*latencySamples)
+	}
+}
+
+func TestPlugin_pluginStats(t *testing.T) {
+	d := &fakeDialer{started: make(chan string, 10), err: errors.New("connection refused")}
+
+	p := &Plugin{connMgr: newTestConnManager(t, d), stats: newCallStats()}
+
+	if _, err := p.connMgr.GetConnection(mustURI(t, "tcp://192.0.2.1:5432"), tlsOptions{}, connOptions{}); err == nil {
+		t.Fatal("ConnManager.GetConnection() error = nil, want connection error")
+	}
+
+	d.err = nil
+
+	if _, err := p.connMgr.GetConnection(mustURI(t, "tcp://192.0.2.2:5432"), tlsOptions{}, connOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	p.stats.record(keyPing, time.Millisecond, nil)
+
+	res, err := p.pluginStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Live        int64 `json:"live_connections"`
+		Created     int64 `json:"created"`
+		Failed      int64 `json:"failed"`
+		Connections []struct {
+			URI string `json:"uri"`
+		} `json:"connections"`
+		Keys map[string]keyStatsJSON `json:"keys"`
+	}
+
+	if err := json.Unmarshal([]byte(res.(string)), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Live != 1 || got.Created != 1 || got.Failed != 1 || len(got.Connections) != 1 ||
+		got.Connections[0].URI != "tcp://192.0.2.2:5432" || got.Keys[keyPing].Calls != 1 {
+		t.Errorf("Plugin.pluginStats() = %s", res)
+	}
+}
@@ -23,10 +23,13 @@ package plugin
 import (
 	"database/sql"
 	"fmt"
+	"net/url"
 	"os"
 	"time"
 
 	"git.zabbix.com/ap/plugin-support/log"
+	"git.zabbix.com/ap/plugin-support/uri"
+	"github.com/omeid/go-yarn"
 )
 
 var sharedConn *PGConn
@@ -60,7 +63,8 @@ func getEnv() (pgAddr, pgUser, pgPwd, pgDb string) {
 func createConnection() error {
 	pgAddr, pgUser, pgPwd, pgDb := getEnv()
 
-	connString := fmt.Sprintf("postgresql://%s:%s@%s/%s", pgUser, pgPwd, pgAddr, pgDb)
+	connString := fmt.Sprintf("postgresql://%s:%s@%s/%s?application_name=%s",
+		pgUser, pgPwd, pgAddr, pgDb, defaultApplicationName)
 
 	newConn, err := sql.Open("pgx", connString)
 	if err != nil {
@@ -78,11 +82,24 @@ func createConnection() error {
 		return err
 	}
 
+	u, err := uri.NewWithCreds(pgAddr+"?dbname="+url.QueryEscape(pgDb), pgUser, pgPwd, uriDefaults)
+	if err != nil {
+		log.Critf("[createConnection] cannot parse PostgreSQL address: %s", err.Error())
+
+		return err
+	}
+
+	connMgr := NewConnManager(300*time.Second, 30*time.Second, 30*time.Second, hkInterval*time.Second,
+		newQueryStore(yarn.NewFromMap(map[string]string{}), builtinCatalogue, queryPolicy{readOnly: true, numericPrecision: -1}))
+
 	sharedConn = &PGConn{
 		client:         newConn,
 		lastTimeAccess: time.Now(),
 		version:        version,
 		callTimeout:    30,
+		uri:            *u,
+		queries:        connMgr.queries,
+		connMgr:        connMgr,
 	}
 
 	return nil
@@ -0,0 +1,368 @@
+/*
+** Zabbix
+** Copyright 2001-2022 Zabbix SIA
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+**     http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+**/
+
+package plugin
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Supported values of the TLSConnect option, they have the same meaning as libpq sslmode.
+const (
+	sslModeDisable    = "disable"
+	sslModeAllow      = "allow"
+	sslModePrefer     = "prefer"
+	sslModeRequire    = "require"
+	sslModeVerifyCA   = "verify-ca"
+	sslModeVerifyFull = "verify-full"
+)
+
+// systemCAFile is a value of TLSCAFile which means the system root CA pool, the same as libpq sslrootcert=system.
+const systemCAFile = "system"
+
+// sslModes maps each accepted value of the TLSConnect option, including the ones supported before libpq names,
+// to the libpq sslmode.
+var sslModes = map[string]string{
+	"":                sslModeDisable,
+	sslModeDisable:    sslModeDisable,
+	sslModeAllow:      sslModeAllow,
+	sslModePrefer:     sslModePrefer,
+	sslModeRequire:    sslModeRequire,
+	sslModeVerifyCA:   sslModeVerifyCA,
+	sslModeVerifyFull: sslModeVerifyFull,
+	"required":        sslModeRequire,
+	"verify_ca":       sslModeVerifyCA,
+	"verify_full":     sslModeVerifyFull,
+}
+
+// tlsVersions maps accepted values of the TLSMinVersion option to TLS versions.
+var tlsVersions = map[string]uint16{
+	"":        0,
+	"TLSv1":   tls.VersionTLS10,
+	"TLSv1.0": tls.VersionTLS10,
+	"TLSv1.1": tls.VersionTLS11,
+	"TLSv1.2": tls.VersionTLS12,
+	"TLSv1.3": tls.VersionTLS13,
+}
+
+// tlsOptions are TLS settings of a connection.
+type tlsOptions struct {
+	mode        string
+	caFile      string
+	certFile    string
+	keyFile     string
+	keyPassword string
+	crlFile     string
+	minVersion  uint16
+	serverName  string
+	systemCA    bool
+}
+
+// newTLSOptions returns TLS settings set by given connection parameters.
+func newTLSOptions(params map[string]string) (tlsOptions, error) {
+	mode, ok := sslModes[params["TLSConnect"]]
+	if !ok {
+		return tlsOptions{}, fmt.Errorf("unsupported TLSConnect %q", params["TLSConnect"])
+	}
+
+	minVersion, ok := tlsVersions[params["TLSMinVersion"]]
+	if !ok {
+		return tlsOptions{}, fmt.Errorf("unsupported TLSMinVersion %q", params["TLSMinVersion"])
+	}
+
+	opts := tlsOptions{
+		mode:        mode,
+		caFile:      params["TLSCAFile"],
+		certFile:    params["TLSCertFile"],
+		keyFile:     params["TLSKeyFile"],
+		keyPassword: params["TLSKeyPassword"],
+		crlFile:     params["TLSCRLFile"],
+		minVersion:  minVersion,
+		serverName:  params["TLSServerName"],
+		systemCA:    params["TLSUseSystemCA"] == "1",
+	}
+
+	if opts.caFile == systemCAFile {
+		opts.caFile = ""
+		opts.systemCA = true
+	}
+
+	if (opts.certFile == "") != (opts.keyFile == "") {
+		return tlsOptions{}, errors.New("TLSCertFile and TLSKeyFile must be set together")
+	}
+
+	if (mode == sslModeVerifyCA || mode == sslModeVerifyFull) && opts.caFile == "" && !opts.systemCA {
+		return tlsOptions{}, fmt.Errorf("TLSConnect %q requires TLSCAFile or the system CA pool", mode)
+	}
+
+	return opts, nil
+}
+
+// configs returns TLS configurations to try in order when connecting to a given host,
+// where nil means an unencrypted connection. Unix-sockets are never encrypted, the same as in libpq.
+func (o tlsOptions) configs(host string) ([]*tls.Config, error) {
+	if o.mode == sslModeDisable || strings.HasPrefix(host, "/") {
+		return []*tls.Config{nil}, nil
+	}
+
+	config, err := o.config(host)
+	if err != nil {
+		return nil, err
+	}
+
+	switch o.mode {
+	case sslModeAllow:
+		return []*tls.Config{nil, config}, nil
+	case sslModePrefer:
+		return []*tls.Config{config, nil}, nil
+	}
+
+	return []*tls.Config{config}, nil
+}
+
+// config returns a TLS configuration to connect to a given host.
+// The certificate chain is verified in the verify-ca and verify-full modes, as well as in the require mode
+// if a CA is set, the same as in libpq. The host name is verified in the verify-full mode only.
+func (o tlsOptions) config(host string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: o.minVersion,
+		ServerName: host,
+	}
+
+	if o.serverName != "" {
+		config.ServerName = o.serverName
+	}
+
+	roots, err := o.rootCAs()
+	if err != nil {
+		return nil, err
+	}
+
+	if o.certFile != "" {
+		cert, err := loadKeyPair(o.certFile, o.keyFile, o.keyPassword)
+		if err != nil {
+			return nil, err
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	crls, err := readCRL(o.crlFile)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case o.mode == sslModeVerifyFull:
+		config.RootCAs = roots
+		if crls != nil {
+			config.VerifyPeerCertificate = verifyPeerCertificate(nil, crls)
+		}
+	case o.mode == sslModeVerifyCA || (o.mode == sslModeRequire && roots != nil):
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = verifyPeerCertificate(roots, crls)
+	default:
+		config.InsecureSkipVerify = true
+	}
+
+	return config, nil
+}
+
+// rootCAs returns a pool of trusted CAs, or nil if there is no one.
+func (o tlsOptions) rootCAs() (*x509.CertPool, error) {
+	var pool *x509.CertPool
+
+	if o.systemCA {
+		var err error
+
+		if pool, err = x509.SystemCertPool(); err != nil {
+			return nil, fmt.Errorf("cannot load system CA pool: %w", err)
+		}
+	}
+
+	if o.caFile != "" {
+		data, err := os.ReadFile(o.caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %q", o.caFile)
+		}
+	}
+
+	return pool, nil
+}
+
+// loadKeyPair loads a client certificate and its private key, which is decrypted with a given password if it is set.
+// Only keys encrypted with the traditional PEM encryption are supported, the same as in pgconn.
+func loadKeyPair(certFile, keyFile, password string) (tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if block, _ := pem.Decode(keyPEM); block != nil && x509.IsEncryptedPEMBlock(block) {
+		if password == "" {
+			return tls.Certificate{}, fmt.Errorf("key %q is encrypted, TLSKeyPassword must be set", keyFile)
+		}
+
+		der, err := x509.DecryptPEMBlock(block, []byte(password))
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("cannot decrypt key %q: %w", keyFile, err)
+		}
+
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// readCRL returns revocation lists of a PEM or DER encoded CRL file, or nil if the file is not set.
+func readCRL(path string) ([]*x509.RevocationList, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ders [][]byte
+
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+
+	if len(ders) == 0 {
+		ders = append(ders, data)
+	}
+
+	crls := make([]*x509.RevocationList, 0, len(ders))
+
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse CRL %q: %w", path, err)
+		}
+
+		crls = append(crls, crl)
+	}
+
+	return crls, nil
+}
+
+// verifyPeerCertificate returns a function verifying the certificate chain of a server against given roots
+// without checking the host name, if roots are set, otherwise the chains verified by crypto/tls are used.
+// Each certificate of a chain is checked against the CRLs of its issuer.
+func verifyPeerCertificate(roots *x509.CertPool,
+	crls []*x509.RevocationList) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		chains := verifiedChains
+
+		if roots != nil {
+			certs := make([]*x509.Certificate, 0, len(rawCerts))
+
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+
+				certs = append(certs, cert)
+			}
+
+			if len(certs) == 0 {
+				return errors.New("server did not present a certificate")
+			}
+
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range certs[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+
+			var err error
+
+			if chains, err = certs[0].Verify(opts); err != nil {
+				return err
+			}
+		}
+
+		for _, chain := range chains {
+			if err := checkRevocation(chain, crls, time.Now()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// checkRevocation checks that no certificate of a verified chain is revoked by a CRL of its issuer.
+// A CRL of the issuer must be signed by it and must not be outdated, the same as in libpq.
+func checkRevocation(chain []*x509.Certificate, crls []*x509.RevocationList, now time.Time) error {
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+
+		for _, crl := range crls {
+			if !bytes.Equal(crl.RawIssuer, issuer.RawSubject) {
+				continue
+			}
+
+			if err := crl.CheckSignatureFrom(issuer); err != nil {
+				return fmt.Errorf("invalid signature of CRL of %q: %w", issuer.Subject.String(), err)
+			}
+
+			if !crl.NextUpdate.IsZero() && now.After(crl.NextUpdate) {
+				return fmt.Errorf("CRL of %q has expired", issuer.Subject.String())
+			}
+
+			for _, entry := range crl.RevokedCertificates {
+				if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return fmt.Errorf("certificate %q is revoked", cert.Subject.String())
+				}
+			}
+		}
+	}
+
+	return nil
+}